@@ -0,0 +1,248 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestGroupArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []calldepth.Option
+	}{
+		{"plain", nil},
+		{"sanitize keys", []calldepth.Option{calldepth.WithSanitizeKeys('_')}},
+		{"numeric coercion", []calldepth.Option{calldepth.WithNumericCoercion()}},
+		{"template", []calldepth.Option{calldepth.WithTemplate()}},
+		{"compaction", []calldepth.Option{calldepth.WithCompaction()}},
+		{"canonical order", []calldepth.Option{calldepth.WithCanonicalOrder()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, buf := newJSON(tt.opts...)
+
+			a.Info("m", slog.Group("db", "q", "select 1", slog.Group("conn", "id", 1)))
+
+			db, _ := single(t, buf)["db"].(map[string]any)
+			conn, _ := db["conn"].(map[string]any)
+
+			if db["q"] != "select 1" || conn["id"] != float64(1) {
+				t.Errorf("db = %v, want the query and the nested connection", db)
+			}
+		})
+	}
+}
+
+func TestConcurrentDerivation(t *testing.T) {
+	a, buf := newJSON(calldepth.WithAttrs(slog.String("service", "test")))
+	parent := a.With("parent", true)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			derived := parent.With("worker", i).WithGroup(fmt.Sprintf("g%d", i))
+
+			derived.InfoContext(context.Background(), "m", "i", i)
+			parent.Info("parent")
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, record := range records(t, buf) {
+		if record["parent"] != true || record["service"] != "test" {
+			t.Errorf("record = %v, want the attributes of the parent", record)
+		}
+
+		if record["msg"] == "parent" && record["worker"] != nil {
+			t.Errorf("record = %v, want no attribute of a derived adapter on the parent", record)
+		}
+	}
+}
+
+// logDeferred logs from a closure it defers.
+func logDeferred(a calldepth.Adapter) {
+	defer func() { calldepth.Deferred(a).Info("done") }()
+}
+
+func TestDeferred(t *testing.T) {
+	a, buf := newJSON()
+
+	logDeferred(a)
+
+	source, _ := single(t, buf)[slog.SourceKey].(map[string]any)
+	if source["function"] != "go.breu.io/slog-utils/calldepth_test.logDeferred" {
+		t.Errorf("source = %v, want the function deferring the closure", source)
+	}
+}
+
+func TestWithWarnOnNilContext(t *testing.T) {
+	var errs []error
+
+	a, buf := newJSON(calldepth.WithWarnOnNilContext(), calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	var ctx context.Context
+
+	a.InfoContext(ctx, "m")
+
+	if len(errs) != 1 || !errors.Is(errs[0], calldepth.ErrNilContext) {
+		t.Errorf("errors = %v, want ErrNilContext", errs)
+	}
+
+	if record := single(t, buf); record["msg"] != "m" {
+		t.Errorf("record = %v, want the record logged anyway", record)
+	}
+}
+
+type (
+	// flushingHandler counts the calls to Flush.
+	flushingHandler struct {
+		slog.Handler
+
+		flushed int
+	}
+)
+
+func (h *flushingHandler) Flush() error {
+	h.flushed++
+
+	return nil
+}
+
+func TestWithFlushMinLevel(t *testing.T) {
+	h := &flushingHandler{Handler: slog.NewJSONHandler(io.Discard, nil)}
+	a := calldepth.New(calldepth.WithLogger(slog.New(h)), calldepth.WithFlushMinLevel(slog.LevelWarn))
+
+	a.Info("info")
+
+	if h.flushed != 0 {
+		t.Errorf("flushed %d times after an info record, want 0", h.flushed)
+	}
+
+	a.Warn("warn")
+
+	if h.flushed != 1 {
+		t.Errorf("flushed %d times after a warn record, want 1", h.flushed)
+	}
+}
+
+func TestWithStrictAttrs(t *testing.T) {
+	var errs []error
+
+	a, buf := newJSON(calldepth.WithStrictAttrs(), calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	a.Info("args", "user", "alice")
+	a.LogAttrs(context.Background(), slog.LevelInfo, "attrs", slog.String("user", "alice"))
+
+	if record := single(t, buf); record["msg"] != "attrs" {
+		t.Errorf("record = %v, want the record logged with LogAttrs only", record)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], calldepth.ErrStrictAttrs) {
+		t.Errorf("errors = %v, want ErrStrictAttrs", errs)
+	}
+}
+
+func TestSetGlobalAttrs(t *testing.T) {
+	before, beforeBuf := newJSON()
+
+	calldepth.SetGlobalAttrs(slog.String("region", "eu"))
+	t.Cleanup(func() { calldepth.SetGlobalAttrs() })
+
+	after, afterBuf := newJSON(calldepth.WithAttrs(slog.String("service", "api")))
+
+	before.Info("m")
+	after.Info("m")
+
+	if record := single(t, beforeBuf); record["region"] != nil {
+		t.Errorf("record = %v, want no global attribute on an adapter created before", record)
+	}
+
+	if !strings.Contains(afterBuf.String(), `"region":"eu","service":"api"`) {
+		t.Errorf("record = %s, want the global attributes before those of WithAttrs", afterBuf)
+	}
+}
+
+// mutate is a hook changing the record.
+func mutate(_ context.Context, record *slog.Record) {
+	record.Message = "mutated"
+	record.AddAttrs(slog.Bool("mutated", true))
+}
+
+func TestWithHook(t *testing.T) {
+	a, buf := newJSON(calldepth.WithHook(mutate))
+
+	a.Info("m")
+
+	if record := single(t, buf); record["msg"] != "mutated" || record["mutated"] != true {
+		t.Errorf("record = %v, want the changes of the hook", record)
+	}
+}
+
+func TestWithHookRecordIsolation(t *testing.T) {
+	var seen string
+
+	a, buf := newJSON(
+		calldepth.WithHookRecordIsolation(),
+		calldepth.WithHook(mutate),
+		calldepth.WithHook(func(_ context.Context, record *slog.Record) { seen = record.Message }),
+	)
+
+	a.Info("m")
+
+	if record := single(t, buf); record["msg"] != "m" || record["mutated"] != nil {
+		t.Errorf("record = %v, want the record as logged", record)
+	}
+
+	if seen != "m" {
+		t.Errorf("hook saw %q, want the record as logged", seen)
+	}
+}
+
+func TestWithOrigin(t *testing.T) {
+	a, buf := newJSON(calldepth.WithOrigin("billing"))
+
+	a.With("user", "alice").Info("m")
+
+	record := single(t, buf)
+	if record[calldepth.OriginKey] != "billing" || record[calldepth.PIDKey] != float64(os.Getpid()) {
+		t.Errorf("record = %v, want the origin and the process id", record)
+	}
+
+	if host, err := os.Hostname(); err == nil && record[calldepth.HostKey] != host {
+		t.Errorf("host = %v, want %v", record[calldepth.HostKey], host)
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type (
+	// levelValue is the value of an attribute returned by AttrForLevel. It resolves to the value of the attribute
+	// where the level of the record is not known, e.g. with With.
+	levelValue struct {
+		level slog.Level
+		value slog.Value
+	}
+)
+
+// AttrForLevel returns the attribute for a record only logged at level or below, e.g. a verbose dump only logged at
+// debug level, for LogAttrs and the methods taking ...any of the adapters created by New. Passed to With, the
+// attribute is always logged.
+func AttrForLevel(level slog.Level, attr slog.Attr) slog.Attr {
+	return slog.Attr{Key: attr.Key, Value: slog.AnyValue(levelValue{level: level, value: attr.Value})}
+}
+
+// WithNamespace prefixes the key of every top level attribute with `prefix.`, e.g. `user` becomes `app.user` and the
+// group `req` becomes `app.req`, leaving the keys of its members as is. Unlike WithGroup, the structure of the record
+// is kept flat. The prefix is added after the other rewrites of the attributes, so their keys are the keys without it.
+func WithNamespace(prefix string) Option {
+	return func(a *adapter) {
+		a.topLevel = append(a.topLevel, func(attr slog.Attr) slog.Attr {
+			if attr.Key != "" {
+				attr.Key = prefix + "." + attr.Key
+			}
+
+			return attr
+		})
+	}
+}
+
+// WithNumericCoercion logs every integer attribute value as an int64 and every floating point value as a float64,
+// including values of named numeric types, so that downstream schemas see a single type per field. Unsigned values
+// too large for an int64 are kept as is.
+func WithNumericCoercion() Option {
+	return func(a *adapter) {
+		a.replacer = append(a.replacer, func(attr slog.Attr) slog.Attr {
+			attr.Value = coerceNumeric(attr.Value)
+
+			return attr
+		})
+	}
+}
+
+// WithValueFormatter replaces the attribute values of the given kind, including those of group members, with the
+// values returned by fn, e.g. DurationMillis to log durations as milliseconds.
+func WithValueFormatter(kind slog.Kind, fn func(slog.Value) slog.Value) Option {
+	return func(a *adapter) {
+		a.replacer = append(a.replacer, func(attr slog.Attr) slog.Attr {
+			if attr.Value.Kind() == kind {
+				attr.Value = fn(attr.Value)
+			}
+
+			return attr
+		})
+	}
+}
+
+// WithFieldTypeEnforcement checks the kind of the values of the attributes, including group members, whose key is in
+// the schema m, and calls onViolation, if set, with the key and the kind of the values of another kind, to catch type
+// drift breaking downstream schemas. Numeric values are coerced as by WithNumericCoercion before they are checked, and
+// values in violation are coerced to the declared kind when they convert without loss, e.g. "42" to an int64, or kept
+// as is otherwise. Every value converts to a string.
+func WithFieldTypeEnforcement(m map[string]slog.Kind, onViolation func(key string, got slog.Kind)) Option {
+	schema := maps.Clone(m)
+
+	return func(a *adapter) {
+		a.replacer = append(a.replacer, func(attr slog.Attr) slog.Attr {
+			want, ok := schema[attr.Key]
+			if !ok {
+				return attr
+			}
+
+			value := coerceNumeric(attr.Value.Resolve())
+			if value.Kind() != want {
+				if onViolation != nil {
+					onViolation(attr.Key, value.Kind())
+				}
+
+				value = coerceKind(value, want)
+			}
+
+			attr.Value = value
+
+			return attr
+		})
+	}
+}
+
+// WithMaxGroupDepth flattens the groups nested more than n deep in the attributes of every record into their parent,
+// joining the keys with dots, e.g. with n set to 2, `a.b.c.d=1` nested four deep becomes the member `c.d` of the
+// group `a.b`, for downstream systems limiting nesting. With n set to 0, groups are flattened into the record. Groups
+// opened with WithGroup are not counted.
+func WithMaxGroupDepth(n int) Option {
+	return func(a *adapter) {
+		a.replacer = append(a.replacer, func(attr slog.Attr) slog.Attr {
+			return limitDepth(attr, max(n, 0))
+		})
+	}
+}
+
+// WithCompaction removes the redundant attributes of every record before it is handled: the attributes equal, key and
+// value, to an earlier attribute at the same level or to one of an enclosing level, e.g. a `user` copied into a group
+// next to the top level `user`. The attribute closest to the top level is kept, and the groups left empty are
+// dropped. The attributes added with With are not compared.
+func WithCompaction() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			attrs := make([]slog.Attr, 0, record.NumAttrs())
+
+			record.Attrs(func(attr slog.Attr) bool {
+				attrs = append(attrs, attr)
+
+				return true
+			})
+
+			compacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+			compacted.AddAttrs(compact(attrs, nil)...)
+
+			*record = compacted
+		})
+	}
+}
+
+// DurationMillis is a value formatter for slog.KindDuration rendering durations as a number of milliseconds.
+func DurationMillis(value slog.Value) slog.Value {
+	return slog.Float64Value(float64(value.Duration()) / float64(time.Millisecond))
+}
+
+// coerceNumeric converts integer values to int64 and floating point values to float64.
+func coerceNumeric(value slog.Value) slog.Value {
+	switch value.Kind() { //nolint:exhaustive // only numeric kinds are coerced.
+	case slog.KindUint64:
+		if u := value.Uint64(); u <= math.MaxInt64 {
+			return slog.Int64Value(int64(u))
+		}
+	case slog.KindAny:
+		v := reflect.ValueOf(value.Any())
+
+		switch v.Kind() { //nolint:exhaustive // only numeric kinds are coerced.
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return slog.Int64Value(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if u := v.Uint(); u <= math.MaxInt64 {
+				return slog.Int64Value(int64(u))
+			}
+		case reflect.Float32, reflect.Float64:
+			return slog.Float64Value(v.Float())
+		}
+	}
+
+	return value
+}
+
+// coerceKind returns the value converted to the kind, or the value itself if it does not convert without loss.
+func coerceKind(value slog.Value, kind slog.Kind) slog.Value {
+	switch kind { //nolint:exhaustive // only the basic kinds are coerced to.
+	case slog.KindString:
+		return slog.StringValue(value.String())
+	case slog.KindInt64:
+		switch value.Kind() { //nolint:exhaustive // only numbers and strings convert.
+		case slog.KindUint64:
+			if u := value.Uint64(); u <= math.MaxInt64 {
+				return slog.Int64Value(int64(u))
+			}
+		case slog.KindFloat64:
+			if f := value.Float64(); f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+				return slog.Int64Value(int64(f))
+			}
+		case slog.KindString:
+			if i, err := strconv.ParseInt(value.String(), 10, 64); err == nil {
+				return slog.Int64Value(i)
+			}
+		}
+	case slog.KindUint64:
+		switch value.Kind() { //nolint:exhaustive // only numbers and strings convert.
+		case slog.KindInt64:
+			if i := value.Int64(); i >= 0 {
+				return slog.Uint64Value(uint64(i))
+			}
+		case slog.KindFloat64:
+			if f := value.Float64(); f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 {
+				return slog.Uint64Value(uint64(f))
+			}
+		case slog.KindString:
+			if u, err := strconv.ParseUint(value.String(), 10, 64); err == nil {
+				return slog.Uint64Value(u)
+			}
+		}
+	case slog.KindFloat64:
+		switch value.Kind() { //nolint:exhaustive // only numbers and strings convert.
+		case slog.KindInt64:
+			return slog.Float64Value(float64(value.Int64()))
+		case slog.KindUint64:
+			return slog.Float64Value(float64(value.Uint64()))
+		case slog.KindString:
+			if f, err := strconv.ParseFloat(value.String(), 64); err == nil {
+				return slog.Float64Value(f)
+			}
+		}
+	case slog.KindBool:
+		if value.Kind() == slog.KindString {
+			if b, err := strconv.ParseBool(value.String()); err == nil {
+				return slog.BoolValue(b)
+			}
+		}
+	}
+
+	return value
+}
+
+// limitDepth returns the attribute with the groups nested more than n deep flattened into their parent. A group
+// flattened at the top level is returned with an empty key, for the handler to inline its members.
+func limitDepth(attr slog.Attr, n int) slog.Attr {
+	if attr.Value.Kind() != slog.KindGroup {
+		return attr
+	}
+
+	members := attr.Value.Group()
+
+	if n == 0 {
+		return slog.Attr{Value: slog.GroupValue(flatten(attr.Key, members, nil)...)}
+	}
+
+	limited := make([]slog.Attr, len(members))
+	for i, member := range members {
+		limited[i] = limitDepth(member, n-1)
+	}
+
+	return slog.Attr{Key: attr.Key, Value: slog.GroupValue(limited...)}
+}
+
+// flatten appends the members of a group to attrs, prefixing their keys with the key of the group, and recursing into
+// the members that are groups themselves.
+func flatten(prefix string, members, attrs []slog.Attr) []slog.Attr {
+	for _, member := range members {
+		key := member.Key
+
+		switch {
+		case key == "":
+			key = prefix
+		case prefix != "":
+			key = prefix + "." + key
+		}
+
+		value := member.Value.Resolve()
+		if value.Kind() == slog.KindGroup {
+			attrs = flatten(key, value.Group(), attrs)
+
+			continue
+		}
+
+		attrs = append(attrs, slog.Attr{Key: key, Value: value})
+	}
+
+	return attrs
+}
+
+// compact returns the attributes without those equal to an earlier attribute of the same level or to one of the
+// enclosing attributes, comparing the members of groups to all the attributes of the level.
+func compact(attrs, enclosing []slog.Attr) []slog.Attr {
+	scope := enclosing[:len(enclosing):len(enclosing)]
+	resolved := make([]slog.Attr, len(attrs))
+	unique := make([]bool, len(attrs))
+
+	for i, attr := range attrs {
+		resolved[i] = slog.Attr{Key: attr.Key, Value: attr.Value.Resolve()}
+
+		if resolved[i].Value.Kind() != slog.KindGroup && !slices.ContainsFunc(scope, equalTo(resolved[i])) {
+			scope = append(scope, resolved[i])
+			unique[i] = true
+		}
+	}
+
+	kept := make([]slog.Attr, 0, len(attrs))
+
+	for i, attr := range resolved {
+		if attr.Value.Kind() != slog.KindGroup {
+			if unique[i] {
+				kept = append(kept, attr)
+			}
+
+			continue
+		}
+
+		if members := compact(attr.Value.Group(), scope); len(members) > 0 {
+			kept = append(kept, slog.Attr{Key: attr.Key, Value: slog.GroupValue(members...)})
+		}
+	}
+
+	return kept
+}
+
+// equalTo returns a function reporting whether an attribute is equal to attr, key and value. Unlike slog.Attr.Equal,
+// values of kind slog.KindAny are compared with reflect.DeepEqual, which does not panic on slices and maps.
+func equalTo(attr slog.Attr) func(other slog.Attr) bool {
+	return func(other slog.Attr) bool {
+		if attr.Value.Kind() == slog.KindAny && other.Value.Kind() == slog.KindAny {
+			return attr.Key == other.Key && reflect.DeepEqual(attr.Value.Any(), other.Value.Any())
+		}
+
+		return attr.Equal(other)
+	}
+}
+
+func (v levelValue) LogValue() slog.Value {
+	return v.value
+}
+
+// attrsForLevel returns the attributes of a record at the given level, without those returned by AttrForLevel for
+// a lower level. The attributes are returned as is if there are none of those.
+func attrsForLevel(level slog.Level, attrs []slog.Attr) []slog.Attr {
+	if !slices.ContainsFunc(attrs, func(attr slog.Attr) bool { return !forLevel(level, attr) }) {
+		return attrs
+	}
+
+	kept := make([]slog.Attr, 0, len(attrs))
+
+	for _, attr := range attrs {
+		if forLevel(level, attr) {
+			kept = append(kept, attr)
+		}
+	}
+
+	return kept
+}
+
+// argsForLevel is attrsForLevel for the arguments of the methods taking ...any.
+func argsForLevel(level slog.Level, args []any) []any {
+	excluded := func(arg any) bool {
+		attr, ok := arg.(slog.Attr)
+
+		return ok && !forLevel(level, attr)
+	}
+
+	if !slices.ContainsFunc(args, excluded) {
+		return args
+	}
+
+	kept := make([]any, 0, len(args))
+
+	for _, arg := range args {
+		if !excluded(arg) {
+			kept = append(kept, arg)
+		}
+	}
+
+	return kept
+}
+
+// forLevel reports whether the attribute is logged with a record at the given level.
+func forLevel(level slog.Level, attr slog.Attr) bool {
+	if attr.Value.Kind() != slog.KindLogValuer {
+		return true
+	}
+
+	v, ok := attr.Value.Any().(levelValue)
+
+	return !ok || level <= v.level
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// count is a named integer type.
+	count int
+
+	// violation is a key and the kind of its value reported by WithFieldTypeEnforcement.
+	violation struct {
+		key  string
+		kind slog.Kind
+	}
+)
+
+func TestWithCompaction(t *testing.T) {
+	a, buf := newJSON(calldepth.WithCompaction())
+
+	a.Info("m",
+		slog.String("user", "alice"),
+		slog.Any("tags", []string{"a"}),
+		slog.Group("g", slog.String("user", "alice"), slog.Any("tags", []string{"a"}), slog.Int("n", 1)),
+		slog.Group("empty", slog.String("user", "alice")),
+	)
+
+	record := single(t, buf)
+
+	if want := map[string]any{"n": float64(1)}; !reflect.DeepEqual(record["g"], want) {
+		t.Errorf("g = %v, want %v", record["g"], want)
+	}
+
+	if _, ok := record["empty"]; ok {
+		t.Errorf("group left empty was kept: %v", record)
+	}
+
+	if record["user"] != "alice" || record["tags"] == nil {
+		t.Errorf("top level attributes were removed: %v", record)
+	}
+}
+
+func TestWithNamespace(t *testing.T) {
+	a, buf := newJSON(calldepth.WithNamespace("app"))
+
+	a.With("service", "api").Info("m", "user", "alice", slog.Group("db", "q", "select 1"))
+
+	record := single(t, buf)
+	db, _ := record["app.db"].(map[string]any)
+
+	if record["app.user"] != "alice" || record["app.service"] != "api" || db["q"] != "select 1" {
+		t.Errorf("record = %v, want the top level keys prefixed with app. and the group members kept", record)
+	}
+
+	if record["msg"] != "m" || record["level"] != "INFO" {
+		t.Errorf("record = %v, want the built-in keys kept", record)
+	}
+}
+
+func TestWithNumericCoercion(t *testing.T) {
+	a, rec := newRecorder(calldepth.WithNumericCoercion())
+
+	a.Info("m",
+		"u8", uint8(3),
+		"count", count(4),
+		"f32", float32(1.5),
+		"big", uint64(math.MaxUint64),
+		slog.Group("g", "u", uint(5)),
+	)
+
+	got := map[string]slog.Kind{}
+
+	rec.records[0].Attrs(func(attr slog.Attr) bool {
+		if attr.Value.Kind() == slog.KindGroup {
+			attr = attr.Value.Group()[0]
+		}
+
+		got[attr.Key] = attr.Value.Kind()
+
+		return true
+	})
+
+	want := map[string]slog.Kind{
+		"u8":    slog.KindInt64,
+		"count": slog.KindInt64,
+		"f32":   slog.KindFloat64,
+		"big":   slog.KindUint64,
+		"u":     slog.KindInt64,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("kinds = %v, want %v", got, want)
+	}
+}
+
+func TestWithValueFormatter(t *testing.T) {
+	a, buf := newJSON(calldepth.WithValueFormatter(slog.KindDuration, calldepth.DurationMillis))
+
+	a.Info("m", "elapsed", 1500*time.Microsecond, slog.Group("db", "elapsed", 2*time.Second), "n", 3)
+
+	record := single(t, buf)
+	db, _ := record["db"].(map[string]any)
+
+	if record["elapsed"] != 1.5 || db["elapsed"] != float64(2000) || record["n"] != float64(3) {
+		t.Errorf("record = %v, want the durations in milliseconds", record)
+	}
+}
+
+func TestWithMaxGroupDepth(t *testing.T) {
+	nested := slog.Group("a", slog.Group("b", slog.Group("c", slog.Int("d", 1)), slog.Int("e", 2)))
+
+	tests := []struct {
+		name  string
+		depth int
+		want  map[string]any
+	}{
+		{"limited", 2, map[string]any{"a": map[string]any{"b": map[string]any{"c.d": float64(1), "e": float64(2)}}}},
+		{"flattened", 0, map[string]any{"a.b.c.d": float64(1), "a.b.e": float64(2)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, buf := newJSON(calldepth.WithMaxGroupDepth(tt.depth))
+
+			a.WithGroup("outer").Info("m", nested)
+
+			record := single(t, buf)
+			if !reflect.DeepEqual(record["outer"], tt.want) {
+				t.Errorf("outer = %v, want %v: groups opened with WithGroup are not counted", record["outer"], tt.want)
+			}
+		})
+	}
+}
+
+func TestAttrForLevel(t *testing.T) {
+	a, buf := newJSON()
+	dump := calldepth.AttrForLevel(slog.LevelDebug, slog.String("dump", "..."))
+
+	a.LogAttrs(context.Background(), slog.LevelDebug, "m", dump)
+	a.LogAttrs(context.Background(), slog.LevelError, "m", dump, slog.Int("n", 1))
+	a.Error("m", dump)
+	a.With(dump).Error("m")
+
+	out := records(t, buf)
+	if len(out) != 4 {
+		t.Fatalf("got %d records, want 4", len(out))
+	}
+
+	if out[0]["dump"] != "..." {
+		t.Errorf("debug record = %v, want the attribute", out[0])
+	}
+
+	for _, record := range out[1:3] {
+		if _, ok := record["dump"]; ok {
+			t.Errorf("error record = %v, want the attribute left out", record)
+		}
+	}
+
+	if out[1]["n"] != float64(1) {
+		t.Errorf("error record = %v, want the other attributes kept", out[1])
+	}
+
+	if out[3]["dump"] != "..." {
+		t.Errorf("record = %v, want the attribute passed to With logged", out[3])
+	}
+}
+
+func TestWithFieldTypeEnforcement(t *testing.T) {
+	var violations []violation
+
+	a, rec := newRecorder(calldepth.WithFieldTypeEnforcement(
+		map[string]slog.Kind{"id": slog.KindInt64, "name": slog.KindString},
+		func(key string, got slog.Kind) { violations = append(violations, violation{key, got}) },
+	))
+
+	a.Info("m", "id", count(1), "name", "alice")
+	a.Info("m", "id", "42", slog.Group("user", "name", 7))
+	a.Info("m", "id", "abc")
+
+	want := []violation{{"id", slog.KindString}, {"name", slog.KindInt64}, {"id", slog.KindString}}
+	if !slices.Equal(violations, want) {
+		t.Errorf("violations = %v, want %v", violations, want)
+	}
+
+	var values []slog.Value
+
+	for _, record := range rec.records {
+		record.Attrs(func(attr slog.Attr) bool {
+			values = append(values, attr.Value)
+
+			return true
+		})
+	}
+
+	kinds := make([]slog.Kind, len(values))
+	for i, value := range values {
+		kinds[i] = value.Kind()
+	}
+
+	// the values are coerced to the kind of the schema when they convert, and kept as is otherwise.
+	if want := []slog.Kind{slog.KindInt64, slog.KindString, slog.KindInt64, slog.KindGroup, slog.KindString}; !slices.Equal(kinds, want) {
+		t.Fatalf("kinds = %v, want %v", kinds, want)
+	}
+
+	if values[2].Int64() != 42 || values[3].Group()[0].Value.String() != "7" || values[4].String() != "abc" {
+		t.Errorf("values = %v, want 42, 7 as a string and abc kept", values)
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"go.breu.io/slog-utils/internal/buffer"
+)
+
+type (
+	// binaryHandler writes records in a compact binary encoding, read back with DecodeRecords.
+	binaryHandler struct {
+		out    *binaryOutput
+		level  slog.Leveler
+		derive []folded
+	}
+
+	// binaryOutput is the writer shared by the handlers derived from a binaryHandler.
+	binaryOutput struct {
+		mu sync.Mutex
+		w  io.Writer
+	}
+
+	// decoder reads the fields of an encoded record, recording the first error.
+	decoder struct {
+		buf []byte
+		err error
+	}
+
+	// RecordReader reads the records written by a binary handler.
+	RecordReader struct {
+		r   *bufio.Reader
+		buf []byte
+	}
+)
+
+const (
+	// maxBinaryRecord is the largest encoded record accepted by RecordReader, to fail on corrupt input rather than
+	// allocate its announced length.
+	maxBinaryRecord = 64 << 20
+
+	// zeroTime encodes the zero time.Time, whose UnixNano is undefined.
+	zeroTime = math.MinInt64
+)
+
+var (
+	// ErrBinaryFormat is returned by RecordReader.Read when the input is not a valid record.
+	ErrBinaryFormat = errors.New("calldepth: invalid binary record")
+)
+
+// WithBinary logs every record at info level and above to w in a compact binary encoding, trading readability for
+// size and speed for very high volumes. Each record is written with a single call to w, prefixed with its length,
+// and is read back with DecodeRecords. Use NewBinaryHandler with WithLogger for another minimum level.
+//
+// The time, level, message and attributes of records are encoded, with the values of kind slog.KindAny encoded as
+// strings; the source is not.
+func WithBinary(w io.Writer) Option {
+	return func(a *adapter) {
+		a.logger = slog.New(NewBinaryHandler(w, slog.LevelInfo))
+	}
+}
+
+// NewBinaryHandler returns a slog.Handler writing the records at or above level to w in the encoding of WithBinary.
+func NewBinaryHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return &binaryHandler{out: &binaryOutput{w: w}, level: level}
+}
+
+// DecodeRecords returns a reader of the records written to r with WithBinary or NewBinaryHandler.
+func DecodeRecords(r io.Reader) *RecordReader {
+	return &RecordReader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next record, or io.EOF once all the records were read. A record cut short is reported as
+// io.ErrUnexpectedEOF.
+func (r *RecordReader) Read() (slog.Record, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return slog.Record{}, err
+	}
+
+	if size > maxBinaryRecord {
+		return slog.Record{}, fmt.Errorf("%w: length %d", ErrBinaryFormat, size)
+	}
+
+	if uint64(cap(r.buf)) < size {
+		r.buf = make([]byte, size)
+	}
+
+	r.buf = r.buf[:size]
+
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return slog.Record{}, err
+	}
+
+	d := decoder{buf: r.buf}
+
+	nanos := d.varint()
+	level := slog.Level(d.varint())
+	msg := d.string()
+
+	t := time.Time{}
+	if nanos != zeroTime {
+		t = time.Unix(0, nanos)
+	}
+
+	record := slog.NewRecord(t, level, msg, 0)
+	record.AddAttrs(d.attrs()...)
+
+	if d.err != nil || len(d.buf) > 0 {
+		return slog.Record{}, ErrBinaryFormat
+	}
+
+	return record, nil
+}
+
+func (h *binaryHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *binaryHandler) Handle(_ context.Context, record slog.Record) error {
+	record = fold(record, h.derive)
+
+	pooled := buffer.New()
+	defer pooled.Free()
+
+	// the body is appended after room for the longest length prefix, which is then written right before it.
+	buf := append(*pooled, make([]byte, binary.MaxVarintLen64)...)
+
+	nanos := int64(zeroTime)
+	if !record.Time.IsZero() {
+		nanos = record.Time.UnixNano()
+	}
+
+	buf = binary.AppendVarint(buf, nanos)
+	buf = binary.AppendVarint(buf, int64(record.Level))
+	buf = appendString(buf, record.Message)
+	buf = binary.AppendUvarint(buf, uint64(record.NumAttrs()))
+
+	record.Attrs(func(attr slog.Attr) bool {
+		buf = appendAttr(buf, attr)
+
+		return true
+	})
+
+	var prefix [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(prefix[:], uint64(len(buf)-binary.MaxVarintLen64))
+	start := binary.MaxVarintLen64 - n
+	copy(buf[start:], prefix[:n])
+
+	*pooled = buf
+
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+
+	_, err := h.out.w.Write(buf[start:])
+
+	return err
+}
+
+func (h *binaryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.derived(folded{attrs: attrs})
+}
+
+func (h *binaryHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return h.derived(folded{group: name})
+}
+
+// derived returns a binaryHandler recording the call to WithAttrs or WithGroup.
+func (h *binaryHandler) derived(derive folded) *binaryHandler {
+	return &binaryHandler{out: h.out, level: h.level, derive: append(h.derive[:len(h.derive):len(h.derive)], derive)}
+}
+
+// appendString appends the string prefixed with its length.
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+
+	return append(buf, s...)
+}
+
+// appendAttr appends the key, the kind and the value of the attribute, and the members of groups.
+func appendAttr(buf []byte, attr slog.Attr) []byte {
+	value := attr.Value.Resolve()
+
+	buf = appendString(buf, attr.Key)
+
+	kind := value.Kind()
+	if kind == slog.KindAny || kind == slog.KindLogValuer {
+		kind = slog.KindString
+	}
+
+	buf = append(buf, byte(kind))
+
+	switch kind { //nolint:exhaustive // the other kinds are encoded as strings.
+	case slog.KindBool:
+		b := byte(0)
+		if value.Bool() {
+			b = 1
+		}
+
+		buf = append(buf, b)
+	case slog.KindDuration:
+		buf = binary.AppendVarint(buf, int64(value.Duration()))
+	case slog.KindFloat64:
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(value.Float64()))
+	case slog.KindInt64:
+		buf = binary.AppendVarint(buf, value.Int64())
+	case slog.KindUint64:
+		buf = binary.AppendUvarint(buf, value.Uint64())
+	case slog.KindTime:
+		buf = binary.AppendVarint(buf, value.Time().UnixNano())
+	case slog.KindGroup:
+		members := value.Group()
+
+		buf = binary.AppendUvarint(buf, uint64(len(members)))
+		for _, member := range members {
+			buf = appendAttr(buf, member)
+		}
+	default:
+		buf = appendString(buf, value.String())
+	}
+
+	return buf
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.buf)
+	d.advance(n)
+
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	d.advance(n)
+
+	return v
+}
+
+func (d *decoder) string() string {
+	size := d.uvarint()
+	if d.err != nil || size > uint64(len(d.buf)) {
+		d.fail()
+
+		return ""
+	}
+
+	s := string(d.buf[:size])
+	d.buf = d.buf[size:]
+
+	return s
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) == 0 {
+		d.fail()
+
+		return 0
+	}
+
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+
+	return b
+}
+
+// attrs reads a count of attributes followed by the attributes.
+func (d *decoder) attrs() []slog.Attr {
+	count := d.uvarint()
+
+	// every attribute takes at least two bytes, bounding the count of a corrupt record.
+	if d.err != nil || count > uint64(len(d.buf)/2) {
+		d.fail()
+
+		return nil
+	}
+
+	attrs := make([]slog.Attr, 0, count)
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		attrs = append(attrs, d.attr())
+	}
+
+	return attrs
+}
+
+func (d *decoder) attr() slog.Attr {
+	key := d.string()
+
+	switch kind := slog.Kind(d.byte()); kind { //nolint:exhaustive // the other kinds are never encoded.
+	case slog.KindBool:
+		return slog.Bool(key, d.byte() == 1)
+	case slog.KindDuration:
+		return slog.Duration(key, time.Duration(d.varint()))
+	case slog.KindFloat64:
+		if len(d.buf) < 8 {
+			d.fail()
+
+			return slog.Attr{}
+		}
+
+		f := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+
+		return slog.Float64(key, f)
+	case slog.KindInt64:
+		return slog.Int64(key, d.varint())
+	case slog.KindUint64:
+		return slog.Uint64(key, d.uvarint())
+	case slog.KindTime:
+		return slog.Time(key, time.Unix(0, d.varint()))
+	case slog.KindGroup:
+		return slog.Attr{Key: key, Value: slog.GroupValue(d.attrs()...)}
+	case slog.KindString:
+		return slog.String(key, d.string())
+	default:
+		d.fail()
+
+		return slog.Attr{}
+	}
+}
+
+// advance consumes n bytes read by a varint function, failing if n reports an error.
+func (d *decoder) advance(n int) {
+	if n <= 0 {
+		d.fail()
+
+		return
+	}
+
+	d.buf = d.buf[n:]
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ErrBinaryFormat
+	}
+
+	d.buf = nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestWithBinary(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := calldepth.New(calldepth.WithBinary(&buf))
+
+	a.With("service", "api").WithGroup("g").Info("request", "n", 1, "elapsed", time.Second, "ok", true)
+	a.Debug("dropped")
+	a.Error("failed", "err", errors.New("unavailable"))
+
+	r := calldepth.DecodeRecords(&buf)
+
+	tests := []struct {
+		level slog.Level
+		msg   string
+		attrs []string
+	}{
+		{slog.LevelInfo, "request", []string{"service=api", "g=[n=1 elapsed=1s ok=true]"}},
+		{slog.LevelError, "failed", []string{"err=unavailable"}},
+	}
+
+	for _, tt := range tests {
+		record, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var attrs []string
+
+		record.Attrs(func(attr slog.Attr) bool {
+			attrs = append(attrs, attr.String())
+
+			return true
+		})
+
+		if record.Level != tt.level || record.Message != tt.msg || !reflect.DeepEqual(attrs, tt.attrs) {
+			t.Errorf("record = %v %q %v, want %v %q %v", record.Level, record.Message, attrs, tt.level, tt.msg, tt.attrs)
+		}
+
+		if record.Time.IsZero() {
+			t.Errorf("record %q has no time", record.Message)
+		}
+	}
+
+	if _, err := r.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("Read after the last record = %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeRecordsTruncated(t *testing.T) {
+	var buf bytes.Buffer
+
+	calldepth.New(calldepth.WithBinary(&buf)).Info("request", "n", 1)
+
+	_, err := calldepth.DecodeRecords(bytes.NewReader(buf.Bytes()[:buf.Len()-1])).Read()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Read of a truncated record = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestWithBinaryConcurrent(t *testing.T) {
+	const (
+		workers   = 8
+		perWorker = 200
+	)
+
+	var (
+		buf bytes.Buffer
+		wg  sync.WaitGroup
+	)
+
+	a := calldepth.New(calldepth.WithBinary(&buf))
+
+	// payload returns the payload of a record, some larger than the buffers kept in the pool.
+	payload := func(worker, i int) string {
+		return strings.Repeat(string(rune('a'+worker)), 1+i*i)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < perWorker; i++ {
+				a.Info("m", "worker", w, "i", i, "payload", payload(w, i))
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	r := calldepth.DecodeRecords(&buf)
+	seen := 0
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := map[string]slog.Value{}
+
+		record.Attrs(func(attr slog.Attr) bool {
+			values[attr.Key] = attr.Value
+
+			return true
+		})
+
+		w, i := int(values["worker"].Int64()), int(values["i"].Int64())
+		if got := values["payload"].String(); got != payload(w, i) {
+			t.Fatalf("payload of record %d of worker %d has %d bytes, want %d: a buffer was shared", i, w, len(got), len(payload(w, i)))
+		}
+
+		seen++
+	}
+
+	if seen != workers*perWorker {
+		t.Errorf("decoded %d records, want %d", seen, workers*perWorker)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+type (
+	// bufferHandler holds the records below a level in a ring buffer, and passes them to the next handler ahead of the
+	// next record at or above the level.
+	bufferHandler struct {
+		next  slog.Handler
+		level slog.Level
+		ring  *ring
+	}
+
+	// ring is a fixed size buffer of records, overwriting the oldest once full. It is shared by the handlers derived
+	// from the same bufferHandler.
+	ring struct {
+		mu      sync.Mutex
+		entries []buffered
+		start   int
+		size    int
+	}
+
+	// buffered is a record held by a ring, with the handler and the context it was logged with.
+	buffered struct {
+		ctx     context.Context //nolint:containedctx // the record must be handled with the context it was logged with.
+		handler slog.Handler
+		derive  []func(h slog.Handler) slog.Handler // derive replays WithAttrs and WithGroup on another handler.
+		record  slog.Record
+	}
+
+	// ReplayBuffer holds the latest records logged by an adapter, to replay them later to another handler. It is safe
+	// for concurrent use.
+	ReplayBuffer struct {
+		ring *ring
+	}
+
+	// captureHandler copies the records it handles to a ReplayBuffer before passing them to the next handler.
+	captureHandler struct {
+		next   slog.Handler
+		derive []func(h slog.Handler) slog.Handler
+		ring   *ring
+	}
+)
+
+// WithBufferBelow holds up to size of the records below level in memory instead of logging them, and logs them ahead of
+// the next record at or above level, so that an error comes with the records leading up to it. Once the buffer is
+// full, the oldest record is dropped.
+//
+// Records below level are always buffered, even if the handler would not log them, and are logged by the handler when
+// the buffer is flushed.
+func WithBufferBelow(level slog.Level, size int) Option {
+	return func(a *adapter) {
+		a.bufferLevel = &level
+		a.bufferSize = size
+	}
+}
+
+// WithReplayBuffer returns an option keeping a copy of the latest records logged, up to size, and the buffer to replay
+// them from, e.g. to a crash report sink once a failure is detected. Records are still passed to the handler of the
+// logger; use a handler discarding them to defer the decision of where they go. Once the buffer is full, the oldest
+// record is dropped.
+func WithReplayBuffer(size int) (Option, *ReplayBuffer) {
+	buffer := &ReplayBuffer{ring: newRing(size)}
+
+	return func(a *adapter) {
+		a.replay = buffer
+	}, buffer
+}
+
+// Replay passes the buffered records, oldest first, to h, with the attributes and groups of the adapters they were
+// logged by, and empties the buffer.
+func (b *ReplayBuffer) Replay(h slog.Handler) error {
+	var errs []error
+
+	for _, entry := range b.ring.drain() {
+		handler := h
+		for _, derive := range entry.derive {
+			handler = derive(handler)
+		}
+
+		if err := handler.Handle(entry.ctx, entry.record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (h *captureHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *captureHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.ring.push(buffered{ctx: ctx, derive: h.derive, record: record.Clone()})
+
+	return h.next.Handle(ctx, record)
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.derived(h.next.WithAttrs(attrs), func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	return h.derived(h.next.WithGroup(name), func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+// derived returns a captureHandler with the given next handler, recording derive for the replay.
+func (h *captureHandler) derived(next slog.Handler, derive func(slog.Handler) slog.Handler) *captureHandler {
+	return &captureHandler{next: next, derive: append(h.derive[:len(h.derive):len(h.derive)], derive), ring: h.ring}
+}
+
+func (h *bufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level < h.level || h.next.Enabled(ctx, level)
+}
+
+func (h *bufferHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level < h.level {
+		h.ring.push(buffered{ctx: ctx, handler: h.next, record: record.Clone()})
+
+		return nil
+	}
+
+	return errors.Join(h.ring.flush(), h.next.Handle(ctx, record))
+}
+
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &bufferHandler{next: h.next.WithAttrs(attrs), level: h.level, ring: h.ring}
+}
+
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	return &bufferHandler{next: h.next.WithGroup(name), level: h.level, ring: h.ring}
+}
+
+// newRing returns a ring holding up to size records.
+func newRing(size int) *ring {
+	return &ring{entries: make([]buffered, max(size, 1))}
+}
+
+// push adds the entry, overwriting the oldest one if the ring is full.
+func (r *ring) push(entry buffered) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[(r.start+r.size)%len(r.entries)] = entry
+
+	if r.size < len(r.entries) {
+		r.size++
+	} else {
+		r.start = (r.start + 1) % len(r.entries)
+	}
+}
+
+// drain removes and returns the entries, oldest first.
+func (r *ring) drain() []buffered {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]buffered, r.size)
+	for i := range entries {
+		idx := (r.start + i) % len(r.entries)
+		entries[i] = r.entries[idx]
+		r.entries[idx] = buffered{}
+	}
+
+	r.start, r.size = 0, 0
+
+	return entries
+}
+
+// flush passes the entries, oldest first, to the handlers they were logged with.
+func (r *ring) flush() error {
+	var errs []error
+
+	for _, entry := range r.drain() {
+		if err := entry.handler.Handle(entry.ctx, entry.record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
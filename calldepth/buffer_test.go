@@ -0,0 +1,88 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"bytes"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+// messages returns the messages of the records.
+func messages(out []map[string]any) []string {
+	msgs := make([]string, 0, len(out))
+	for _, record := range out {
+		msg, _ := record["msg"].(string)
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func TestWithBufferBelow(t *testing.T) {
+	a, buf := newJSONAt(slog.LevelInfo, calldepth.WithBufferBelow(slog.LevelError, 2))
+
+	a.Debug("oldest")
+	a.Info("info")
+	a.Debug("debug")
+
+	if buf.Len() != 0 {
+		t.Fatalf("records logged before an error: %s", buf)
+	}
+
+	a.Error("error")
+
+	if got, want := messages(records(t, buf)), []string{"info", "debug", "error"}; !slices.Equal(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+}
+
+func TestWithReplayBuffer(t *testing.T) {
+	replay, buffer := calldepth.WithReplayBuffer(3)
+	a, _ := newJSON(replay)
+
+	for _, msg := range []string{"evicted", "first", "second"} {
+		a.Info(msg)
+	}
+
+	a.With("user", "alice").Info("third")
+
+	var out bytes.Buffer
+	if err := buffer.Replay(slog.NewJSONHandler(&out, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := records(t, &out)
+	if got, want := messages(replayed), []string{"first", "second", "third"}; !slices.Equal(got, want) {
+		t.Fatalf("messages = %v, want %v", got, want)
+	}
+
+	if replayed[2]["user"] != "alice" {
+		t.Errorf("record = %v, want the attributes of the adapter", replayed[2])
+	}
+
+	out.Reset()
+
+	if err := buffer.Replay(slog.NewJSONHandler(&out, nil)); err != nil || out.Len() != 0 {
+		t.Errorf("replayed %q again, want an empty buffer", out.String())
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package calldepthtest provides helpers to test and benchmark calldepth adapters.
+package calldepthtest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+// BenchmarkAdapter runs the standard benchmarks of the package against the adapter, reporting allocations, so that
+// handler configurations can be compared consistently. The Disabled benchmarks assume that debug records are not
+// logged by the adapter; DisabledGuarded checks Enabled first, as hot paths should, and allocates nothing.
+func BenchmarkAdapter(b *testing.B, a calldepth.Adapter) {
+	b.Helper()
+
+	ctx := context.Background()
+	err := errors.New("benchmark")
+
+	b.Run("Enabled", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			_ = a.Enabled(ctx, slog.LevelInfo)
+		}
+	})
+
+	b.Run("Disabled", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			a.LogAttrs(ctx, slog.LevelDebug, "benchmark", slog.Int("iteration", i))
+		}
+	})
+
+	b.Run("DisabledGuarded", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if a.Enabled(ctx, slog.LevelDebug) {
+				a.LogAttrs(ctx, slog.LevelDebug, "benchmark", slog.Int("iteration", i))
+			}
+		}
+	})
+
+	b.Run("Info", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			a.InfoContext(ctx, "benchmark", "iteration", i, "error", err)
+		}
+	})
+
+	b.Run("LogAttrs", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			a.LogAttrs(ctx, slog.LevelInfo, "benchmark", slog.Int("iteration", i), slog.Any("error", err))
+		}
+	})
+
+	b.Run("With", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			a.With("iteration", i).InfoContext(ctx, "benchmark")
+		}
+	})
+}
+
+// BenchmarkHandler runs the standard benchmarks of the package against the handler, reporting allocations, both from
+// a single goroutine and from concurrent ones, so that the write paths of handlers can be compared consistently.
+func BenchmarkHandler(b *testing.B, h slog.Handler) {
+	b.Helper()
+
+	ctx := context.Background()
+
+	record := slog.NewRecord(time.Now(), slog.LevelInfo, "benchmark", 0)
+	record.AddAttrs(slog.Int("iteration", 1), slog.String("user", "benchmark"), slog.Duration("elapsed", time.Second))
+
+	b.Run("Handle", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			_ = h.Handle(ctx, record)
+		}
+	})
+
+	b.Run("HandleParallel", func(b *testing.B) {
+		b.ReportAllocs()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = h.Handle(ctx, record)
+			}
+		})
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepthtest
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// writer writes each line through the Log method of a test, so that it is attributed to the test and only shown
+	// when the test fails or runs verbosely.
+	writer struct {
+		t testing.TB
+	}
+)
+
+// WithTestLogger logs the records, at all levels and with their source, through t.Log.
+func WithTestLogger(t testing.TB) calldepth.Option {
+	handler := slog.NewTextHandler(&writer{t: t}, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug})
+
+	return calldepth.WithLogger(slog.New(handler))
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.t.Helper()
+
+	// t.Log adds a newline of its own.
+	w.t.Log(string(bytes.TrimSuffix(p, []byte("\n"))))
+
+	return len(p), nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepthtest_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/calldepth/calldepthtest"
+)
+
+type (
+	// fakeTB records the lines logged through Log.
+	fakeTB struct {
+		testing.TB
+
+		lines []string
+	}
+)
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Log(args ...any) {
+	tb.lines = append(tb.lines, fmt.Sprint(args...))
+}
+
+func TestWithTestLogger(t *testing.T) {
+	tb := &fakeTB{}
+	a := calldepth.New(calldepthtest.WithTestLogger(tb))
+
+	a.Debug("first", "user", "alice")
+	a.Info("second")
+
+	if len(tb.lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(tb.lines), tb.lines)
+	}
+
+	if line := tb.lines[0]; strings.HasSuffix(line, "\n") || !strings.Contains(line, "level=DEBUG") ||
+		!strings.Contains(line, "msg=first user=alice") || !strings.Contains(line, "logger_test.go:") {
+		t.Errorf("line = %q, want the debug record with its source and without the trailing newline", line)
+	}
+}
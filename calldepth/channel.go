@@ -0,0 +1,138 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"log/slog"
+)
+
+type (
+	// ChannelPolicy is what WithChannel does with a record when the channel is full.
+	ChannelPolicy int
+
+	// channelHandler sends the records it handles to a channel, with the attributes and groups added with WithAttrs
+	// and WithGroup folded into them.
+	channelHandler struct {
+		ch      chan<- slog.Record
+		policy  ChannelPolicy
+		primary slog.Handler // primary is the handler of the adapter, whose levels the channel follows.
+		derive  []folded
+	}
+
+	// folded is a call to WithAttrs, or to WithGroup if group is set, to fold into the records of a handler.
+	folded struct {
+		group string
+		attrs []slog.Attr
+	}
+)
+
+const (
+	// ChannelBlock waits for the channel to accept the record, or for the context of the record to be done.
+	ChannelBlock ChannelPolicy = iota
+
+	// ChannelDrop discards the record.
+	ChannelDrop
+)
+
+// WithChannel sends every record logged by the handler to ch as well, e.g. for tests or in-process consumers reacting
+// to the logs. Only the records at the levels enabled by the handler of the adapter are sent. The records carry the
+// attributes added with With, and onFull sets what is done when ch is full.
+func WithChannel(ch chan<- slog.Record, onFull ChannelPolicy) Option {
+	return func(a *adapter) {
+		a.channels = append(a.channels, &channelHandler{ch: ch, policy: onFull})
+	}
+}
+
+func (h *channelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.primary.Enabled(ctx, level)
+}
+
+func (h *channelHandler) Handle(ctx context.Context, record slog.Record) error {
+	record = fold(record, h.derive)
+
+	if h.policy == ChannelDrop {
+		select {
+		case h.ch <- record:
+		default:
+		}
+
+		return nil
+	}
+
+	select {
+	case h.ch <- record:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *channelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.derived(folded{attrs: attrs})
+}
+
+func (h *channelHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return h.derived(folded{group: name})
+}
+
+// derived returns a channelHandler recording the call to WithAttrs or WithGroup.
+func (h *channelHandler) derived(derive folded) *channelHandler {
+	return &channelHandler{
+		ch:      h.ch,
+		policy:  h.policy,
+		primary: h.primary,
+		derive:  append(h.derive[:len(h.derive):len(h.derive)], derive),
+	}
+}
+
+// fold returns a copy of the record with the attributes and groups added to its handler, nesting its attributes in
+// the groups opened after the attributes were added. Empty groups are left out, as slog handlers do.
+func fold(record slog.Record, derive []folded) slog.Record {
+	if len(derive) == 0 {
+		return record.Clone()
+	}
+
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+
+		return true
+	})
+
+	for i := len(derive) - 1; i >= 0; i-- {
+		switch {
+		case derive[i].group == "":
+			attrs = append(derive[i].attrs[:len(derive[i].attrs):len(derive[i].attrs)], attrs...)
+		case len(attrs) > 0:
+			attrs = []slog.Attr{{Key: derive[i].group, Value: slog.GroupValue(attrs...)}}
+		}
+	}
+
+	result := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	result.AddAttrs(attrs...)
+
+	return result
+}
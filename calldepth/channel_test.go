@@ -0,0 +1,76 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestWithChannel(t *testing.T) {
+	ch := make(chan slog.Record, 4)
+	a := calldepth.New(
+		calldepth.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		calldepth.WithChannel(ch, calldepth.ChannelBlock),
+	)
+
+	if a.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("debug enabled by the channel, want the level of the handler")
+	}
+
+	a.Debug("disabled")
+	a.WithGroup("req").With("id", 1).Info("sent")
+
+	if len(ch) != 1 {
+		t.Fatalf("got %d records, want 1", len(ch))
+	}
+
+	record := <-ch
+
+	var got slog.Attr
+
+	record.Attrs(func(attr slog.Attr) bool {
+		got = attr
+
+		return true
+	})
+
+	if record.Message != "sent" || got.Key != "req" || got.Value.Group()[0].Key != "id" {
+		t.Errorf("record = %q with %v, want sent with req.id", record.Message, got)
+	}
+}
+
+func TestWithChannelDrop(t *testing.T) {
+	ch := make(chan slog.Record, 1)
+	a := calldepth.New(
+		calldepth.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		calldepth.WithChannel(ch, calldepth.ChannelDrop),
+	)
+
+	a.Info("first")
+	a.Info("second")
+
+	if record := <-ch; record.Message != "first" || len(ch) != 0 {
+		t.Errorf("got %q and %d more records, want first only", record.Message, len(ch))
+	}
+}
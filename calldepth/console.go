@@ -0,0 +1,185 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+
+	"go.breu.io/slog-utils/internal/buffer"
+)
+
+type (
+	// consoleHandler formats records with a slog.TextHandler, prefixing each line with the icon of its level, in
+	// color, when writing to a terminal.
+	consoleHandler struct {
+		text slog.Handler
+		out  *consoleOutput
+	}
+
+	// consoleOutput is the output shared by the handlers derived from a consoleHandler.
+	consoleOutput struct {
+		mu    sync.Mutex
+		w     io.Writer
+		buf   *buffer.Buffer // buf is the buffer of the record being handled, taken from the pool.
+		tty   bool
+		icons []levelIcon // icons is sorted by level.
+	}
+
+	// levelIcon is the icon and the ANSI color of the records at or above a level.
+	levelIcon struct {
+		level slog.Level
+		icon  string
+		color string
+	}
+
+	// consoleBuffer is the writer the text handler formats records into.
+	consoleBuffer consoleOutput
+)
+
+const (
+	ansiReset = "\x1b[0m"
+)
+
+var (
+	// defaultIcons are the icons of the standard levels.
+	defaultIcons = []levelIcon{
+		{level: slog.LevelDebug, icon: "•", color: "\x1b[90m"},
+		{level: slog.LevelInfo, icon: "✓", color: "\x1b[32m"},
+		{level: slog.LevelWarn, icon: "⚠", color: "\x1b[33m"},
+		{level: slog.LevelError, icon: "✗", color: "\x1b[31m"},
+	}
+)
+
+// WithConsole logs the records to w in the format of slog.TextHandler, for local development. When w is a terminal,
+// each line is prefixed with the icon of its level, in color; see WithLevelIcon.
+func WithConsole(w io.Writer, opts *slog.HandlerOptions) Option {
+	return func(a *adapter) {
+		a.console = w
+		a.consoleOpts = opts
+	}
+}
+
+// WithLevelIcon sets the icon prefixing the lines of the records at or above level, up to the next level with an icon,
+// in the output of WithConsole.
+func WithLevelIcon(level slog.Level, icon string) Option {
+	return func(a *adapter) {
+		a.consoleIcons = append(a.consoleIcons, levelIcon{level: level, icon: icon})
+	}
+}
+
+// newConsoleHandler returns a consoleHandler writing to w, with the default icons overridden by icons.
+func newConsoleHandler(w io.Writer, opts *slog.HandlerOptions, icons []levelIcon) *consoleHandler {
+	out := &consoleOutput{w: w, tty: isTerminal(w), icons: slices.Clone(defaultIcons)}
+
+	for _, custom := range icons {
+		idx := slices.IndexFunc(out.icons, func(li levelIcon) bool { return li.level == custom.level })
+		if idx < 0 {
+			out.icons = append(out.icons, custom)
+
+			continue
+		}
+
+		out.icons[idx].icon = custom.icon
+	}
+
+	slices.SortFunc(out.icons, func(a, b levelIcon) int { return int(a.level) - int(b.level) })
+
+	return &consoleHandler{text: slog.NewTextHandler((*consoleBuffer)(out), opts), out: out}
+}
+
+func (h *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.text.Enabled(ctx, level)
+}
+
+func (h *consoleHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+
+	h.out.buf = buffer.New()
+	defer h.out.release()
+
+	if err := h.text.Handle(ctx, record); err != nil {
+		return err
+	}
+
+	if h.out.tty {
+		if li, ok := h.out.icon(record.Level); ok {
+			prefix := li.icon + " "
+			if li.color != "" {
+				prefix = li.color + li.icon + ansiReset + " "
+			}
+
+			if _, err := io.WriteString(h.out.w, prefix); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := h.out.w.Write(*h.out.buf)
+
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &consoleHandler{text: h.text.WithAttrs(attrs), out: h.out}
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	return &consoleHandler{text: h.text.WithGroup(name), out: h.out}
+}
+
+// icon returns the icon of the highest level at or below the given level.
+func (o *consoleOutput) icon(level slog.Level) (levelIcon, bool) {
+	for i := len(o.icons) - 1; i >= 0; i-- {
+		if o.icons[i].level <= level {
+			return o.icons[i], true
+		}
+	}
+
+	return levelIcon{}, false
+}
+
+// release returns the buffer of the record handled to the pool.
+func (o *consoleOutput) release() {
+	o.buf.Free()
+	o.buf = nil
+}
+
+// Write appends to the buffer of the output. It is called by the text handler while the output is locked.
+func (b *consoleBuffer) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := file.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
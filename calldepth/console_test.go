@@ -0,0 +1,63 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestConsoleIcons(t *testing.T) {
+	var buf bytes.Buffer
+
+	h := newConsoleHandler(&buf, nil, []levelIcon{{level: slog.LevelWarn, icon: "!"}})
+	h.out.tty = true
+
+	a := New(WithLogger(slog.New(h)))
+
+	a.Info("info")
+	a.Warn("warn")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+
+	if want := "\x1b[32m✓" + ansiReset + " time="; !strings.HasPrefix(lines[0], want) {
+		t.Errorf("info line = %q, want the default icon in color", lines[0])
+	}
+
+	if want := "\x1b[33m!" + ansiReset + " time="; !strings.HasPrefix(lines[1], want) {
+		t.Errorf("warn line = %q, want the custom icon in the color of the level", lines[1])
+	}
+}
+
+func TestConsoleWithoutTerminal(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := New(WithConsole(&buf, nil))
+
+	a.Info("info")
+
+	if !strings.HasPrefix(buf.String(), "time=") {
+		t.Errorf("line = %q, want no icon when not writing to a terminal", buf.String())
+	}
+}
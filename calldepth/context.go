@@ -0,0 +1,541 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// ContextExtractor returns the attributes to add to a record from the context the record was logged with.
+	ContextExtractor func(ctx context.Context) []slog.Attr
+
+	// ChainedExtractor is given the attributes extracted so far for a record and returns the attributes to continue
+	// with, so it can read, replace or drop what earlier extractors added.
+	ChainedExtractor func(ctx context.Context, attrs []slog.Attr) []slog.Attr
+
+	// logValueKey is the context key for the value stored with ContextWithLogValue.
+	logValueKey struct{}
+
+	// groupKey is the context key for the group stored with ContextWithGroup.
+	groupKey struct{}
+
+	// cacheKey is the context key for the cache stored with ContextWithExtractorCache.
+	cacheKey struct{}
+
+	// adapterKey is the context key for the adapter stored with IntoContext.
+	adapterKey struct{}
+
+	// extractorCache holds the attributes extracted from a context, per set of extractors.
+	extractorCache struct {
+		mu      sync.Mutex
+		entries map[*ChainedExtractor]cachedAttrs
+		ids     map[any]string // ids are the correlation ids generated by WithCorrelationID, per context key.
+	}
+
+	// namedKey is a context key and the key of the attribute its value is logged as.
+	namedKey struct {
+		name string
+		key  any
+	}
+
+	// cachedAttrs are the attributes extracted from a context.
+	cachedAttrs struct {
+		ctx   context.Context //nolint:containedctx // the context is only compared to, to invalidate the entry.
+		attrs []slog.Attr
+	}
+)
+
+var (
+	// ErrExtractorTimeout is reported when an extractor runs for longer than allowed by WithExtractorTimeout.
+	ErrExtractorTimeout = errors.New("calldepth: context extractor timed out")
+
+	// ErrNoContextLogger is reported by FromContext when the context carries no adapter, with WithRequireContextLogger.
+	ErrNoContextLogger = errors.New("calldepth: no adapter in context")
+)
+
+const (
+	// CorrelationIDKey is the key of the attribute added by WithCorrelationID.
+	CorrelationIDKey = "correlation_id"
+
+	// ContextValueKey is the key of the attribute holding a value stored with ContextWithLogValue that does not
+	// resolve to a group.
+	ContextValueKey = "context"
+)
+
+// WithContextExtractor registers an extractor that is run for every record.
+//
+// Extractors run in the order they were registered. If an extractor returns an attribute whose key was already added
+// by an earlier extractor, the later value replaces the earlier one in place.
+func WithContextExtractor(fn ContextExtractor) Option {
+	return WithChainedExtractor(func(ctx context.Context, attrs []slog.Attr) []slog.Attr {
+		return mergeAttrs(attrs, fn(ctx))
+	})
+}
+
+// WithChainedExtractor registers an extractor that can see the attributes added by the extractors registered before
+// it. It runs in registration order together with the extractors added by WithContextExtractor.
+func WithChainedExtractor(fn ChainedExtractor) Option {
+	return func(a *adapter) {
+		a.extractors = append(a.extractors, fn)
+	}
+}
+
+// WithAllContextValues logs the value of each of the context keys of the map, if present, with the attribute key it
+// is mapped from, e.g. {"user": userKey{}}, in the order of the attribute keys.
+//
+// Go does not allow iterating over all the values of a context, so the keys must be listed explicitly. This is meant
+// as a debugging aid, not for production use.
+func WithAllContextValues(keys map[string]any) Option {
+	named := namedKeys(keys)
+
+	return WithContextExtractor(func(ctx context.Context) []slog.Attr {
+		return contextValues(ctx, named)
+	})
+}
+
+// SnapshotContext returns an adapter logging the values of the context keys of the map, read once now, with every
+// record, with the attribute keys they are mapped from as for WithAllContextValues. This avoids extracting the values
+// for every record, at the cost of not seeing later changes.
+func SnapshotContext(ctx context.Context, a Adapter, keys map[string]any) Adapter {
+	return a.With(attrsToArgs(contextValues(ctx, namedKeys(keys)))...)
+}
+
+// AuditSnapshot returns an adapter logging the attributes extracted from the context now with every record, in place
+// of extracting them for each record, for audit records that must show the context at the time of the event. The
+// attributes are frozen: their values are resolved, and those of kind slog.KindAny are copied as strings, so that
+// neither later changes to the context nor concurrent writers to the values alter the records. Adapters not created
+// by New have no extractors and are returned as is.
+func AuditSnapshot(ctx context.Context, a Adapter) Adapter {
+	impl, ok := a.(*adapter)
+	if !ok {
+		return a
+	}
+
+	if ctx == nil {
+		ctx = impl.background()
+	}
+
+	c := impl.with(attrsToArgs(freeze(impl.runExtractors(ctx)))...)
+	c.extractors = nil
+
+	return c
+}
+
+// freeze returns a copy of the attributes with their values resolved, recursively into groups, and the values of kind
+// slog.KindAny replaced by their string form.
+func freeze(attrs []slog.Attr) []slog.Attr {
+	frozen := make([]slog.Attr, len(attrs))
+
+	for i, attr := range attrs {
+		value := attr.Value.Resolve()
+
+		switch value.Kind() { //nolint:exhaustive // the other kinds are immutable.
+		case slog.KindGroup:
+			value = slog.GroupValue(freeze(value.Group())...)
+		case slog.KindAny:
+			value = slog.StringValue(value.String())
+		}
+
+		frozen[i] = slog.Attr{Key: attr.Key, Value: value}
+	}
+
+	return frozen
+}
+
+// WithContextLogValue adds the attributes of the value stored with ContextWithLogValue to every record. The value is
+// resolved for each record that is logged, and only then, so expensive fields are computed only when needed.
+func WithContextLogValue() Option {
+	return WithContextExtractor(func(ctx context.Context) []slog.Attr {
+		lv, ok := ctx.Value(logValueKey{}).(slog.LogValuer)
+		if !ok {
+			return nil
+		}
+
+		value := slog.AnyValue(lv).Resolve()
+		if value.Kind() == slog.KindGroup {
+			return value.Group()
+		}
+
+		return []slog.Attr{{Key: ContextValueKey, Value: value}}
+	})
+}
+
+// ContextWithLogValue returns a copy of the context carrying a value resolving to the attributes added to the
+// records by adapters created with WithContextLogValue, usually a group.
+func ContextWithLogValue(ctx context.Context, lv slog.LogValuer) context.Context {
+	return context.WithValue(ctx, logValueKey{}, lv)
+}
+
+// WithContextGroup nests the attributes of every record, including the extracted ones, under the group stored with
+// ContextWithGroup, e.g. the name of the current operation. Records logged with a context without one are not
+// changed. Attributes added with With are not nested.
+func WithContextGroup() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(ctx context.Context, record *slog.Record) {
+			name, ok := ctx.Value(groupKey{}).(string)
+			if !ok || name == "" || record.NumAttrs() == 0 {
+				return
+			}
+
+			attrs := make([]slog.Attr, 0, record.NumAttrs())
+
+			record.Attrs(func(attr slog.Attr) bool {
+				attrs = append(attrs, attr)
+
+				return true
+			})
+
+			grouped := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+			grouped.AddAttrs(slog.Attr{Key: name, Value: slog.GroupValue(attrs...)})
+
+			*record = grouped
+		})
+	}
+}
+
+// ContextWithGroup returns a copy of the context carrying the group the records are nested under by adapters created
+// with WithContextGroup.
+func ContextWithGroup(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, groupKey{}, name)
+}
+
+// WithCorrelationID adds the correlation id stored in the context under key, usually by ContextWithCorrelationID, to
+// every record. Store one in the context with ContextWithCorrelationID, e.g. in a middleware, to correlate all the
+// records of a request. If the context has none, the id generated is kept in the cache of a context created with
+// ContextWithExtractorCache, shared by the contexts derived from it; without a cache, a new id is generated for every
+// record.
+func WithCorrelationID(key any) Option {
+	return WithContextExtractor(func(ctx context.Context) []slog.Attr {
+		id, ok := correlationID(ctx, key)
+		if !ok {
+			id = generatedID(ctx, key)
+		}
+
+		return []slog.Attr{slog.String(CorrelationIDKey, id)}
+	})
+}
+
+// ContextWithCorrelationID returns the context if it carries a correlation id under key, or a copy of it carrying a
+// new one, along with the id.
+func ContextWithCorrelationID(ctx context.Context, key any) (context.Context, string) {
+	if id, ok := correlationID(ctx, key); ok {
+		return ctx, id
+	}
+
+	id := newUUID()
+
+	return context.WithValue(ctx, key, id), id
+}
+
+// correlationID returns the correlation id stored in the context under key, as a string.
+func correlationID(ctx context.Context, key any) (string, bool) {
+	switch id := ctx.Value(key).(type) {
+	case string:
+		return id, id != ""
+	case fmt.Stringer:
+		return id.String(), true
+	default:
+		return "", false
+	}
+}
+
+// generatedID returns the correlation id generated for a context carrying none under key, kept in the extractor cache
+// of the context, if any.
+func generatedID(ctx context.Context, key any) string {
+	if cache, ok := ctx.Value(cacheKey{}).(*extractorCache); ok {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		if cache.ids == nil {
+			cache.ids = make(map[any]string)
+		}
+
+		if _, ok := cache.ids[key]; !ok {
+			cache.ids[key] = newUUID()
+		}
+
+		return cache.ids[key]
+	}
+
+	return newUUID()
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+
+	_, _ = rand.Read(b[:])
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// DetachContext returns a context carrying the values of ctx, and thus the values logged by the extractors, but not
+// its deadline nor its cancellation, e.g. for a goroutine outliving the request it was started from.
+func DetachContext(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
+
+// namedKeys returns the context keys of the map with their attribute keys, sorted by attribute key.
+func namedKeys(keys map[string]any) []namedKey {
+	named := make([]namedKey, 0, len(keys))
+	for name, key := range keys {
+		named = append(named, namedKey{name: name, key: key})
+	}
+
+	slices.SortFunc(named, func(x, y namedKey) int { return cmp.Compare(x.name, y.name) })
+
+	return named
+}
+
+// contextValues returns the values of the given context keys that are present, keyed by their attribute keys.
+func contextValues(ctx context.Context, keys []namedKey) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(keys))
+
+	for _, key := range keys {
+		if val := ctx.Value(key.key); val != nil {
+			attrs = append(attrs, slog.Any(key.name, val))
+		}
+	}
+
+	return attrs
+}
+
+// attrsToArgs returns the attributes as arguments for With.
+func attrsToArgs(attrs []slog.Attr) []any {
+	args := make([]any, len(attrs))
+	for i, attr := range attrs {
+		args[i] = attr
+	}
+
+	return args
+}
+
+// argsToAttrs converts key-value pairs and attributes to attributes, the way slog does for records.
+func argsToAttrs(args []any) []slog.Attr {
+	record := slog.NewRecord(time.Time{}, 0, "", 0)
+	record.Add(args...)
+
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+
+		return true
+	})
+
+	return attrs
+}
+
+// WithExtractorTimeout abandons the extractors that run for longer than d, e.g. because they do I/O, reporting
+// ErrExtractorTimeout to the error handler and logging the record without their attributes. An abandoned extractor
+// keeps running in its own goroutine until it returns.
+//
+// Every extractor runs in a goroutine of its own with this option, which adds to the cost of every record.
+func WithExtractorTimeout(d time.Duration) Option {
+	return func(a *adapter) {
+		a.extractTimeout = d
+	}
+}
+
+// WithExtractorCache reuses the attributes extracted for a context carrying a cache, stored with
+// ContextWithExtractorCache, for all the records logged with that same context, so that the extractors run once per
+// request instead of once per record. A context derived from it, e.g. with context.WithValue, runs the extractors
+// again. Extractors whose result changes over time for the same context should not be used with this option.
+func WithExtractorCache() Option {
+	return func(a *adapter) {
+		a.cacheExtract = true
+	}
+}
+
+// ContextWithExtractorCache returns a copy of the context carrying a cache for the attributes extracted by adapters
+// created with WithExtractorCache.
+func ContextWithExtractorCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheKey{}, &extractorCache{entries: make(map[*ChainedExtractor]cachedAttrs)})
+}
+
+// extract runs the extractors, or returns their cached result for the context.
+func (a *adapter) extract(ctx context.Context) []slog.Attr {
+	cache, ok := ctx.Value(cacheKey{}).(*extractorCache)
+	if !a.cacheExtract || !ok || !reflect.TypeOf(ctx).Comparable() {
+		return a.runExtractors(ctx)
+	}
+
+	// adapters derived from one another share their extractors, and so their entry.
+	owner := &a.extractors[0]
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if entry, ok := cache.entries[owner]; ok && entry.ctx == ctx {
+		return entry.attrs
+	}
+
+	attrs := a.runExtractors(ctx)
+	cache.entries[owner] = cachedAttrs{ctx: ctx, attrs: attrs}
+
+	return attrs
+}
+
+// runExtractors runs the extractors in order and returns the resulting attributes.
+func (a *adapter) runExtractors(ctx context.Context) []slog.Attr {
+	var attrs []slog.Attr
+
+	for _, fn := range a.extractors {
+		if a.extractTimeout > 0 {
+			attrs = a.extractWithTimeout(ctx, fn, attrs)
+		} else {
+			attrs = fn(ctx, attrs)
+		}
+	}
+
+	return attrs
+}
+
+// extractWithTimeout runs the extractor on a copy of the attributes, returning them unchanged if it times out.
+func (a *adapter) extractWithTimeout(ctx context.Context, fn ChainedExtractor, attrs []slog.Attr) []slog.Attr {
+	result := make(chan []slog.Attr, 1)
+	in := slices.Clone(attrs)
+
+	go func() {
+		result <- fn(ctx, in)
+	}()
+
+	timer := time.NewTimer(a.extractTimeout)
+	defer timer.Stop()
+
+	select {
+	case out := <-result:
+		return out
+	case <-timer.C:
+		a.report(ErrExtractorTimeout)
+
+		return attrs
+	}
+}
+
+// mergeAttrs adds next to attrs, replacing the attributes of attrs that have the same key.
+func mergeAttrs(attrs, next []slog.Attr) []slog.Attr {
+	for _, attr := range next {
+		replaced := false
+
+		for i := range attrs {
+			if attrs[i].Key == attr.Key {
+				attrs[i] = attr
+				replaced = true
+
+				break
+			}
+		}
+
+		if !replaced {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs
+}
+
+// IntoContext returns a copy of ctx carrying the adapter, e.g. one derived with the attributes of a request, for the
+// code handling it to retrieve with FromContext.
+func IntoContext(ctx context.Context, a Adapter) context.Context {
+	return context.WithValue(ctx, adapterKey{}, a)
+}
+
+// FromContext returns the adapter stored in ctx with IntoContext, or the default adapter if there is none. If the
+// default adapter was created with WithRequireContextLogger, the fallback is reported to its error handler.
+func FromContext(ctx context.Context) Adapter {
+	if a, ok := ctx.Value(adapterKey{}).(Adapter); ok {
+		return a
+	}
+
+	fallback := Default()
+	if a, ok := fallback.(*adapter); ok && a.requireCtx {
+		a.report(ErrNoContextLogger)
+	}
+
+	return fallback
+}
+
+// GoWithLogger runs fn in a new goroutine with a context detached from the cancellation of ctx, carrying its values
+// and the adapter returned by FromContext(ctx), so that the background work logs with the attributes of the work that
+// launched it and outlives it. A panic of fn is recovered and logged with RecoverAndLog.
+func GoWithLogger(ctx context.Context, fn func(ctx context.Context)) {
+	a := FromContext(ctx)
+	ctx = IntoContext(context.WithoutCancel(ctx), a)
+
+	go func() {
+		defer RecoverAndLog(ctx, a)
+
+		fn(ctx)
+	}()
+}
+
+// WithRequireContextLogger reports ErrNoContextLogger to the error handler whenever FromContext falls back to the
+// adapter because the context carries none, for the adapter set as the default, e.g. with WithSetDefault. Records
+// are still logged by the default adapter, so the option can be enabled in tests only to enforce context propagation
+// without breaking production logging.
+func WithRequireContextLogger() Option {
+	return func(a *adapter) {
+		a.requireCtx = true
+	}
+}
+
+// Merge returns the adapter with the top level attributes added with With to the adapter stored in ctx with
+// IntoContext, except those with a key the adapter has too, so that attributes given explicitly take precedence over
+// those of the request. If a group is open on the adapter, the attributes are added to the group, so Merge is best
+// called before WithGroup. The adapter is returned as is if ctx has none.
+func Merge(ctx context.Context, a Adapter) Adapter {
+	from, ok := ctx.Value(adapterKey{}).(*adapter)
+	if !ok {
+		return a
+	}
+
+	explicit, ok := a.(*adapter)
+	if !ok {
+		return a
+	}
+
+	var args []any
+
+	for _, attr := range from.scoped {
+		if !slices.ContainsFunc(explicit.scoped, func(other slog.Attr) bool { return other.Key == attr.Key }) {
+			args = append(args, attr)
+		}
+	}
+
+	if len(args) == 0 {
+		return a
+	}
+
+	return explicit.with(args...)
+}
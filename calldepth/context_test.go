@@ -0,0 +1,456 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	userKey        struct{}
+	tenantKey      struct{}
+	correlationKey struct{}
+
+	// countingValuer counts the times it is resolved.
+	countingValuer struct {
+		resolved int
+	}
+
+	// signalingHandler sends the message of every record it handled on a channel, to wait for records logged by
+	// other goroutines.
+	signalingHandler struct {
+		slog.Handler
+
+		handled chan string
+	}
+)
+
+func (v *countingValuer) LogValue() slog.Value {
+	v.resolved++
+
+	return slog.GroupValue(slog.String("user", "alice"))
+}
+
+func (h *signalingHandler) Handle(ctx context.Context, record slog.Record) error {
+	err := h.Handler.Handle(ctx, record)
+	h.handled <- record.Message
+
+	return err
+}
+
+func (h *signalingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &signalingHandler{Handler: h.Handler.WithAttrs(attrs), handled: h.handled}
+}
+
+func (h *signalingHandler) WithGroup(name string) slog.Handler {
+	return &signalingHandler{Handler: h.Handler.WithGroup(name), handled: h.handled}
+}
+
+func TestWithAllContextValues(t *testing.T) {
+	a, buf := newJSON(calldepth.WithAllContextValues(map[string]any{"user": userKey{}, "tenant": tenantKey{}}))
+	ctx := context.WithValue(context.WithValue(context.Background(), userKey{}, "alice"), tenantKey{}, "acme")
+
+	a.InfoContext(ctx, "m")
+
+	record := single(t, buf)
+	if record["user"] != "alice" || record["tenant"] != "acme" {
+		t.Errorf("user = %v, tenant = %v, want alice and acme", record["user"], record["tenant"])
+	}
+}
+
+func TestSnapshotContext(t *testing.T) {
+	a, buf := newJSON()
+	ctx := context.WithValue(context.Background(), userKey{}, "alice")
+
+	snapshot := calldepth.SnapshotContext(ctx, a, map[string]any{"user": userKey{}, "tenant": tenantKey{}})
+	ctx = context.WithValue(ctx, userKey{}, "bob")
+
+	snapshot.InfoContext(ctx, "m")
+
+	record := single(t, buf)
+	if _, ok := record["tenant"]; record["user"] != "alice" || ok {
+		t.Errorf("record = %v, want the user at the time of the snapshot only", record)
+	}
+}
+
+func TestSnapshotContextDerived(t *testing.T) {
+	a, buf := newJSON()
+	ctx := context.WithValue(context.Background(), userKey{}, "alice")
+
+	snapshot := calldepth.SnapshotContext(ctx, a, map[string]any{"user": userKey{}})
+
+	snapshot.InfoContext(context.Background(), "snapshot")
+	snapshot.Info("snapshot")
+	a.InfoContext(ctx, "parent")
+
+	out := records(t, buf)
+	if len(out) != 3 {
+		t.Fatalf("got %d records, want 3", len(out))
+	}
+
+	for _, record := range out[:2] {
+		if record["user"] != "alice" {
+			t.Errorf("record = %v, want the snapshot logged with every record, whatever the context", record)
+		}
+	}
+
+	if _, ok := out[2]["user"]; ok {
+		t.Errorf("record = %v, want the adapter the snapshot derives from unchanged", out[2])
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	a, buf := newJSON()
+	ctx := calldepth.IntoContext(context.Background(), a.With("request", "r1"))
+
+	calldepth.FromContext(ctx).InfoContext(ctx, "handled")
+
+	if got := single(t, buf)["request"]; got != "r1" {
+		t.Errorf("request = %v, want r1", got)
+	}
+}
+
+func TestFromContextWithoutAdapter(t *testing.T) {
+	// the default adapter is created on first use, even if SetDefault was never called.
+	calldepth.FromContext(context.Background()).Debug("no adapter in context")
+}
+
+func TestWithRequireContextLogger(t *testing.T) {
+	previous := calldepth.Default()
+	t.Cleanup(func() { calldepth.SetDefault(previous) })
+
+	var errs []error
+
+	a, buf := newJSON(
+		calldepth.WithRequireContextLogger(),
+		calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+		calldepth.WithSetDefault(),
+	)
+
+	calldepth.FromContext(context.Background()).Info("fallback")
+	calldepth.FromContext(calldepth.IntoContext(context.Background(), a)).Info("stored")
+
+	if got := len(records(t, buf)); got != 2 {
+		t.Errorf("logged %d records, want 2: the fallback still logs", got)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], calldepth.ErrNoContextLogger) {
+		t.Errorf("errors = %v, want ErrNoContextLogger once", errs)
+	}
+}
+
+func TestWithCorrelationID(t *testing.T) {
+	a, buf := newJSON(calldepth.WithCorrelationID(correlationKey{}))
+	ctx, id := calldepth.ContextWithCorrelationID(context.Background(), correlationKey{})
+
+	a.InfoContext(ctx, "m")
+
+	if record := single(t, buf); record[calldepth.CorrelationIDKey] != id {
+		t.Errorf("correlation id = %v, want %s", record[calldepth.CorrelationIDKey], id)
+	}
+}
+
+func TestWithCorrelationIDGenerated(t *testing.T) {
+	a, buf := newJSON(calldepth.WithCorrelationID(correlationKey{}))
+	ctx := context.WithValue(context.Background(), userKey{}, "alice")
+
+	a.InfoContext(ctx, "first")
+	a.InfoContext(ctx, "second")
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	first, second := out[0][calldepth.CorrelationIDKey], out[1][calldepth.CorrelationIDKey]
+	if first == nil || first == "" || first == second {
+		t.Errorf("correlation ids = %v and %v, want a new id for every record of a context without an id", first, second)
+	}
+}
+
+func TestWithCorrelationIDInterleaved(t *testing.T) {
+	a, buf := newJSON(calldepth.WithCorrelationID(correlationKey{}))
+
+	stored, id := calldepth.ContextWithCorrelationID(context.Background(), correlationKey{})
+	cached := calldepth.ContextWithExtractorCache(context.Background())
+
+	// the records of two requests interleaved on the same adapter.
+	a.InfoContext(stored, "stored")
+	a.InfoContext(cached, "cached")
+	a.InfoContext(stored, "stored")
+	a.InfoContext(cached, "cached")
+
+	out := records(t, buf)
+	if len(out) != 4 {
+		t.Fatalf("got %d records, want 4", len(out))
+	}
+
+	if out[0][calldepth.CorrelationIDKey] != id || out[2][calldepth.CorrelationIDKey] != id {
+		t.Errorf("records = %v, want the stored id %s for both records of the first request", out, id)
+	}
+
+	generated := out[1][calldepth.CorrelationIDKey]
+	if generated == nil || generated == id || out[3][calldepth.CorrelationIDKey] != generated {
+		t.Errorf("records = %v, want one generated id for both records of the second request", out)
+	}
+}
+
+func TestWithCorrelationIDExtractorCache(t *testing.T) {
+	a, buf := newJSON(calldepth.WithCorrelationID(correlationKey{}))
+	ctx := calldepth.ContextWithExtractorCache(context.Background())
+
+	a.InfoContext(context.WithValue(ctx, userKey{}, "alice"), "first")
+	a.InfoContext(context.WithValue(ctx, userKey{}, "bob"), "second")
+
+	out := records(t, buf)
+	if len(out) != 2 || out[0][calldepth.CorrelationIDKey] != out[1][calldepth.CorrelationIDKey] {
+		t.Errorf("records = %v, want the same generated id for the contexts derived from the cache", out)
+	}
+}
+
+func TestWithContextExtractorOrder(t *testing.T) {
+	var seen []string
+
+	a, buf := newJSON(
+		calldepth.WithContextExtractor(func(context.Context) []slog.Attr {
+			return []slog.Attr{slog.Int("a", 1), slog.Int("b", 1)}
+		}),
+		calldepth.WithContextExtractor(func(context.Context) []slog.Attr {
+			return []slog.Attr{slog.Int("b", 2), slog.Int("c", 2)}
+		}),
+		calldepth.WithChainedExtractor(func(_ context.Context, attrs []slog.Attr) []slog.Attr {
+			for _, attr := range attrs {
+				seen = append(seen, attr.String())
+			}
+
+			return attrs
+		}),
+	)
+
+	a.InfoContext(context.Background(), "m")
+
+	if !strings.Contains(buf.String(), `"a":1,"b":2,"c":2`) {
+		t.Errorf("record = %s, want a, b replaced in place by the later extractor, and c", buf)
+	}
+
+	if got := strings.Join(seen, " "); got != "a=1 b=2 c=2" {
+		t.Errorf("chained extractor saw %q, want the attributes of the earlier extractors", got)
+	}
+}
+
+func TestWithContextLogValue(t *testing.T) {
+	a, buf := newJSONAt(slog.LevelInfo, calldepth.WithContextLogValue())
+	lv := &countingValuer{}
+	ctx := calldepth.ContextWithLogValue(context.Background(), lv)
+
+	a.DebugContext(ctx, "disabled")
+
+	if lv.resolved != 0 {
+		t.Errorf("resolved %d times for a record not logged, want 0", lv.resolved)
+	}
+
+	a.InfoContext(ctx, "m")
+
+	if record := single(t, buf); record["user"] != "alice" || lv.resolved != 1 {
+		t.Errorf("record = %v after %d resolutions, want the user resolved once", record, lv.resolved)
+	}
+}
+
+func TestWithContextGroup(t *testing.T) {
+	a, buf := newJSON(calldepth.WithContextGroup())
+
+	a.With("service", "api").InfoContext(calldepth.ContextWithGroup(context.Background(), "import"), "m", "rows", 3)
+	a.InfoContext(context.Background(), "m", "rows", 4)
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	group, _ := out[0]["import"].(map[string]any)
+	if group["rows"] != float64(3) || out[0]["service"] != "api" {
+		t.Errorf("record = %v, want the attributes of the record nested under import", out[0])
+	}
+
+	if out[1]["rows"] != float64(4) {
+		t.Errorf("record = %v, want no group without one in the context", out[1])
+	}
+}
+
+func TestWithExtractorTimeout(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	var errs []error
+
+	a, buf := newJSON(
+		calldepth.WithExtractorTimeout(10*time.Millisecond),
+		calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+		calldepth.WithContextExtractor(func(context.Context) []slog.Attr {
+			return []slog.Attr{slog.String("user", "alice")}
+		}),
+		calldepth.WithContextExtractor(func(context.Context) []slog.Attr {
+			<-release
+
+			return []slog.Attr{slog.String("slow", "value")}
+		}),
+	)
+
+	a.InfoContext(context.Background(), "m")
+
+	if record := single(t, buf); record["user"] != "alice" || record["slow"] != nil {
+		t.Errorf("record = %v, want the attributes of the fast extractor only", record)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], calldepth.ErrExtractorTimeout) {
+		t.Errorf("errors = %v, want ErrExtractorTimeout", errs)
+	}
+}
+
+func TestDetachContext(t *testing.T) {
+	a, buf := newJSON(calldepth.WithAllContextValues(map[string]any{"user": userKey{}}))
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), userKey{}, "alice"), time.Hour)
+	detached := calldepth.DetachContext(ctx)
+
+	cancel()
+
+	if _, ok := detached.Deadline(); ok || detached.Err() != nil {
+		t.Errorf("detached context has the deadline or the cancellation of its parent")
+	}
+
+	a.InfoContext(detached, "m")
+
+	if record := single(t, buf); record["user"] != "alice" {
+		t.Errorf("record = %v, want the values of the parent", record)
+	}
+}
+
+func TestWithExtractorCache(t *testing.T) {
+	runs := 0
+
+	a, buf := newJSON(
+		calldepth.WithExtractorCache(),
+		calldepth.WithContextExtractor(func(context.Context) []slog.Attr {
+			runs++
+
+			return []slog.Attr{slog.Int("run", runs)}
+		}),
+	)
+
+	ctx := calldepth.ContextWithExtractorCache(context.Background())
+
+	a.InfoContext(ctx, "first")
+	a.With("user", "alice").InfoContext(ctx, "second")
+
+	if runs != 1 {
+		t.Errorf("extractors ran %d times for the same context, want 1", runs)
+	}
+
+	a.InfoContext(context.WithValue(ctx, userKey{}, "bob"), "derived")
+
+	if runs != 2 {
+		t.Errorf("extractors ran %d times, want them to run again for a derived context", runs)
+	}
+
+	out := records(t, buf)
+	if len(out) != 3 || out[1]["run"] != float64(1) || out[2]["run"] != float64(2) {
+		t.Errorf("records = %v, want the cached attributes", out)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	request, _ := newJSON()
+	ctx := calldepth.IntoContext(context.Background(), request.With("request", "r1", "user", "alice"))
+
+	a, buf := newJSON()
+	calldepth.Merge(ctx, a.With("user", "bob")).Info("m")
+
+	record := single(t, buf)
+	if record["request"] != "r1" || record["user"] != "bob" {
+		t.Errorf("record = %v, want request from the context and the explicit user", record)
+	}
+
+	if got := calldepth.Merge(context.Background(), a); got != a {
+		t.Errorf("Merge without a context adapter = %v, want the adapter as is", got)
+	}
+}
+
+func TestGoWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	h := &signalingHandler{Handler: slog.NewJSONHandler(&buf, nil), handled: make(chan string, 2)}
+	a := calldepth.New(calldepth.WithLogger(slog.New(h)))
+
+	ctx, cancel := context.WithCancel(calldepth.IntoContext(context.Background(), a.With("request", "r1")))
+	cancel()
+
+	calldepth.GoWithLogger(ctx, func(ctx context.Context) {
+		calldepth.FromContext(ctx).InfoContext(ctx, "background", "canceled", ctx.Err() != nil)
+		panic("boom")
+	})
+
+	for _, want := range []string{"background", calldepth.PanicMessage} {
+		select {
+		case msg := <-h.handled:
+			if msg != want {
+				t.Fatalf("message = %q, want %q", msg, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	out := records(t, &buf)
+	if out[0]["request"] != "r1" || out[0]["canceled"] != false {
+		t.Errorf("record = %v, want the attributes of the parent and a context detached from its cancellation", out[0])
+	}
+
+	if out[1]["request"] != "r1" || out[1][calldepth.ErrorKey] != "panic: boom" {
+		t.Errorf("record = %v, want the panic logged with the attributes of the parent", out[1])
+	}
+}
+
+func TestAuditSnapshot(t *testing.T) {
+	a, buf := newJSON(calldepth.WithAllContextValues(map[string]any{"user": userKey{}, "roles": tenantKey{}}))
+
+	roles := []string{"viewer"}
+	ctx := context.WithValue(context.WithValue(context.Background(), userKey{}, "alice"), tenantKey{}, roles)
+
+	audit := calldepth.AuditSnapshot(ctx, a)
+
+	// later changes to the context and to the values.
+	roles[0] = "admin"
+	ctx = context.WithValue(ctx, userKey{}, "mallory")
+
+	audit.InfoContext(ctx, "granted")
+
+	record := single(t, buf)
+	if record["user"] != "alice" || record["roles"] != "[viewer]" {
+		t.Errorf("record = %v, want the context at the time of the snapshot", record)
+	}
+}
@@ -21,14 +21,24 @@ package calldepth
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log/slog"
-	"runtime"
+	"os"
 	"sync/atomic"
 	"time"
 )
 
 type (
 	// Adapter is the interface that wraps the slog.Logger with a call depth.
+	//
+	// A record that is not logged costs no allocation within the adapter. The arguments of the variadic methods are
+	// allocated by their caller though, since the compiler cannot tell that they do not escape through the
+	// interface, so hot paths logging at a level usually disabled should check Enabled first:
+	//
+	//	if a.Enabled(ctx, slog.LevelDebug) {
+	//		a.LogAttrs(ctx, slog.LevelDebug, "cache hit", slog.String("key", key))
+	//	}
 	Adapter interface {
 		Debug(msg string, args ...any)
 		DebugContext(ctx context.Context, msg string, args ...any)
@@ -48,25 +58,106 @@ type (
 
 	// adapter is the implementation of Adapter.
 	adapter struct {
-		logger *slog.Logger // logger is the underlying logger.
-		depth  int          // depth gives the call depth of the caller. DefaultCallDepth is 3. For 3rd pary adapters, this should be 4.
+		logger         *slog.Logger                   // logger is the underlying logger.
+		depth          int                            // depth gives the call depth of the caller. DefaultCallDepth is 3. For 3rd pary adapters, this should be 4.
+		extractors     []ChainedExtractor             // extractors add attributes from the context to every record.
+		onError        func(err error)                // onError is called when a record could not be handled.
+		fallback       slog.Handler                   // fallback handles records the underlying handler failed to handle.
+		message        []func(string) string          // message rewrites the message of every record.
+		replacer       []func(slog.Attr) slog.Attr    // replacer rewrites every attribute, including those inside groups.
+		topLevel       []func(slog.Attr) slog.Attr    // topLevel rewrites the attributes out of groups, after replacer.
+		warnNilCtx     bool                           // warnNilCtx reports ErrNilContext when a nil context is passed.
+		hooks          []Hook                         // hooks modify every record before it is handled.
+		flushLevel     *slog.Level                    // flushLevel is the minimum level of records after which flusher is flushed.
+		flusher        Flusher                        // flusher is the underlying handler, if it implements Flusher.
+		sampler        Sampler                        // sampler decides which records are logged.
+		ctxSampling    bool                           // ctxSampling honors the sample decision stored in the context.
+		strictAttrs    bool                           // strictAttrs disables the methods taking ...any.
+		attrs          []slog.Attr                    // attrs are added to every record, after the global attributes.
+		userCode       []string                       // userCode lists the package prefixes skipped to find the source, if set.
+		verboseLevel   *slog.Level                    // verboseLevel is the minimum level logged with a context flagged verbose, if set.
+		dropped        *slog.Logger                   // dropped logs the records dropped by the sampler, if set.
+		bufferLevel    *slog.Level                    // bufferLevel is the level below which records are buffered, if set.
+		bufferSize     int                            // bufferSize is the number of records buffered below bufferLevel.
+		selector       func(slog.Record) slog.Handler // selector chooses the handler of each record, if set.
+		filters        []filter                       // filters drop the records for which one of them returns false.
+		emitted        []emitObserver                 // emitted are called with the records passed to the handler.
+		text           slog.Handler                   // text is the text handler records are passed to as well, if set.
+		textOmit       []string                       // textOmit lists the keys removed from the records passed to text.
+		replay         *ReplayBuffer                  // replay keeps a copy of the records logged, if set.
+		ctxDepth       bool                           // ctxDepth adds the depth delta stored in the context to depth.
+		extractTimeout time.Duration                  // extractTimeout bounds how long each extractor may run, if not zero.
+		isolateHooks   bool                           // isolateHooks passes a copy of the record to the hooks added with WithHook.
+		remap          func(slog.Level) slog.Level    // remap changes the level of records before they are checked and handled, if set.
+		stats          *samplingStats                 // stats counts the records kept and dropped by the sampler, if set.
+		cacheExtract   bool                           // cacheExtract reuses the extracted attributes for the same context.
+		console        io.Writer                      // console is the writer records are logged to by WithConsole, if set.
+		consoleOpts    *slog.HandlerOptions           // consoleOpts are the options of the console handler.
+		consoleIcons   []levelIcon                    // consoleIcons override the default icons of the console handler.
+		channels       []*channelHandler              // channels are the handlers sending the records to the channels set with WithChannel.
+		required       []string                       // required are the keys every record must have, set with WithSchema.
+		scoped         []slog.Attr                    // scoped are the attributes added with With before any group was opened, for Merge.
+		grouped        bool                           // grouped is set once a group is opened with WithGroup.
+		skipAnon       bool                           // skipAnon attributes records logged from closures to the nearest named function.
+		dedupSize      int                            // dedupSize bounds the keys tracked by WithDebounce, if not zero.
+		canonical      bool                           // canonical sorts the attributes of every record by key, set with WithCanonicalOrder.
+		requireCtx     bool                           // requireCtx reports ErrNoContextLogger when FromContext falls back to the adapter, set as the default.
+		panicRing      *ring                          // panicRing keeps the latest records that were not logged, for RecoverAndLog, if set.
+		redact         map[string]bool                // redact are the keys whose values are replaced by WithRedactKeys, after the other rewrites.
+		subsystem      *slog.LevelVar                 // subsystem is the level of the subsystem set with WithSubsystemLevel, checked instead of the handler, if set.
+		sourceLevel    *slog.LevelVar                 // sourceLevel is the level below which the source of records is not looked up, set with WithSourceLevelVar.
+		breakAfter     int                            // breakAfter is the number of consecutive failures after which records are logged to stderr, set with WithStderrFallback.
 	}
 
 	// Option provides a way to configure the adapter.
 	Option func(*adapter)
+
+	// Flusher is implemented by handlers that buffer records, e.g. in a bufio.Writer, and can write them out on demand.
+	Flusher interface {
+		Flush() error
+	}
+
+	// Hook is called with every record before it is handled, and may modify it.
+	Hook func(ctx context.Context, record *slog.Record)
+
+	// filter reports whether a record is handled, after the hooks have run. It may modify the record it keeps.
+	filter func(ctx context.Context, record *slog.Record) bool
 )
 
 const (
 	// DefaultCallDepth helps skip Callers, the adapter.log function, and the adapter.log function's caller.
 	DefaultCallDepth = 3
+
+	// OriginKey is the key of the attribute holding the name of the service added by WithOrigin.
+	OriginKey = "origin"
+
+	// HostKey is the key of the attribute holding the host name added by WithOrigin.
+	HostKey = "host"
+
+	// PIDKey is the key of the attribute holding the process id added by WithOrigin.
+	PIDKey = "pid"
+
+	// asyncErrors is the capacity of the channel returned by WithAsyncErrors.
+	asyncErrors = 64
 )
 
 var (
-	store atomic.Value
+	store   atomic.Value
+	globals atomic.Value
+
+	// ErrNilContext is reported when a nil context is passed to the adapter and WithWarnOnNilContext is set.
+	ErrNilContext = errors.New("calldepth: nil context")
+
+	// ErrStrictAttrs is reported when a method taking ...any is called on an adapter created with WithStrictAttrs.
+	ErrStrictAttrs = errors.New("calldepth: ...any arguments disabled, use LogAttrs")
 )
 
 func (a *adapter) Enabled(ctx context.Context, level slog.Level) bool {
-	return a.logger.Enabled(ctx, level)
+	if ctx == nil {
+		ctx = a.background()
+	}
+
+	return a.enabled(ctx, a.level(level))
 }
 
 func (a *adapter) Handler() slog.Handler {
@@ -74,17 +165,69 @@ func (a *adapter) Handler() slog.Handler {
 }
 
 func (a *adapter) With(args ...any) Adapter {
-	return &adapter{
-		logger: a.logger.With(args...),
-		depth:  a.depth,
+	return a.with(args...)
+}
+
+// with is With returning the concrete type, for the methods that log through the derived adapter.
+func (a *adapter) with(args ...any) *adapter {
+	c := a.clone(a.logger.With(args...))
+	if c.dropped != nil {
+		c.dropped = c.dropped.With(args...)
 	}
+
+	if !c.grouped {
+		c.scoped = append(a.scoped[:len(a.scoped):len(a.scoped)], argsToAttrs(args)...)
+	}
+
+	return c
 }
 
 func (a *adapter) WithGroup(name string) Adapter {
-	return &adapter{
-		logger: slog.New(a.logger.Handler().WithGroup(name)),
-		depth:  a.depth,
+	c := a.clone(slog.New(a.logger.Handler().WithGroup(name)))
+	if c.dropped != nil {
+		c.dropped = c.dropped.WithGroup(name)
 	}
+
+	c.grouped = c.grouped || name != ""
+
+	return c
+}
+
+// Deferred returns an adapter for logging from a deferred closure, e.g.
+//
+//	defer func() { calldepth.Deferred(a).Info("done") }()
+//
+// It skips the closure's frame so that the source points at the function that deferred it. Go reports the line at
+// which that function is returning rather than the line of the defer statement. The adapter is not suitable for
+// calls made outside a closure, e.g. `defer a.Info("done")`, which are already attributed to the calling function.
+func Deferred(a Adapter) Adapter {
+	c := adapterOf(a)
+	c = c.clone(c.logger)
+	c.depth++
+
+	return c
+}
+
+// adapterOf returns a if it was created by New or, for the other implementations of Adapter, an adapter logging to
+// the handler of a, so that the functions taking an Adapter report the source of their caller either way.
+func adapterOf(a Adapter) *adapter {
+	if impl, ok := a.(*adapter); ok {
+		return impl
+	}
+
+	return &adapter{logger: slog.New(a.Handler()), depth: DefaultCallDepth}
+}
+
+// clone returns a copy of the adapter with the given logger, keeping the rest of the configuration.
+//
+// The copy shares the slices of the configuration with the adapter. This is safe because they are only appended to by
+// the options, before New returns, and are read-only afterwards; the attributes given to With are held by the
+// handler returned by slog.Logger.With, which copies them.
+func (a *adapter) clone(logger *slog.Logger) *adapter {
+	c := *a
+	c.logger = logger
+
+	return &c
 }
 
 func (a *adapter) Log(ctx context.Context, level slog.Level, msg string, args ...any) {
@@ -128,46 +271,168 @@ func (a *adapter) ErrorContext(ctx context.Context, msg string, args ...any) {
 }
 
 func (a *adapter) log(ctx context.Context, level slog.Level, msg string, args ...any) {
-	if !a.logger.Enabled(ctx, level) {
+	if a.strictAttrs {
+		a.report(ErrStrictAttrs)
+
+		return
+	}
+
+	if ctx == nil {
+		ctx = a.background()
+	}
+
+	level = a.level(level)
+
+	if !a.enabled(ctx, level) {
+		if a.panicRing != nil {
+			record := slog.NewRecord(time.Now(), level, msg, 0)
+			if a.sourceEnabled(level) {
+				record.PC = a.caller(ctx)
+			}
+
+			record.Add(argsForLevel(level, args)...)
+			a.remember(ctx, record)
+		}
+
 		return
 	}
 
-	var pcs [1]uintptr
+	sampled := a.sampled(ctx, level)
+	if !sampled && a.dropped == nil {
+		return
+	}
 
-	runtime.Callers(a.depth, pcs[:])
+	record := slog.NewRecord(time.Now(), level, msg, 0)
+	if a.sourceEnabled(level) {
+		record.PC = a.caller(ctx)
+	}
 
-	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
-	record.Add(args...)
+	record.Add(argsForLevel(level, args)...)
 
-	if ctx == nil {
-		ctx = context.Background()
+	a.handle(ctx, record, sampled)
+}
+
+// argsAttrs converts the arguments of the functions taking ...any on top of their own attributes, e.g. LogError, to
+// attributes. With WithStrictAttrs, only the arguments that are a slog.Attr are kept, and ErrStrictAttrs is reported
+// if there are others, so that the record is still logged.
+func (a *adapter) argsAttrs(args []any) []slog.Attr {
+	if !a.strictAttrs {
+		return argsToAttrs(args)
+	}
+
+	attrs := make([]slog.Attr, 0, len(args))
+
+	for _, arg := range args {
+		if attr, ok := arg.(slog.Attr); ok {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	if len(attrs) < len(args) {
+		a.report(ErrStrictAttrs)
 	}
 
-	_ = a.logger.Handler().Handle(ctx, record)
+	return attrs
 }
 
 func (a *adapter) logattrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
-	if !a.logger.Enabled(ctx, level) {
+	if ctx == nil {
+		ctx = a.background()
+	}
+
+	level = a.level(level)
+
+	if !a.enabled(ctx, level) {
+		if a.panicRing != nil {
+			record := slog.NewRecord(time.Now(), level, msg, 0)
+			if a.sourceEnabled(level) {
+				record.PC = a.caller(ctx)
+			}
+
+			record.AddAttrs(attrsForLevel(level, attrs)...)
+			a.remember(ctx, record)
+		}
+
 		return
 	}
 
-	var pcs [1]uintptr
+	sampled := a.sampled(ctx, level)
+	if !sampled && a.dropped == nil {
+		return
+	}
 
-	runtime.Callers(a.depth, pcs[:])
+	record := slog.NewRecord(time.Now(), level, msg, 0)
+	if a.sourceEnabled(level) {
+		record.PC = a.caller(ctx)
+	}
 
-	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
-	record.AddAttrs(attrs...)
+	record.AddAttrs(attrsForLevel(level, attrs)...)
 
-	if ctx == nil {
-		ctx = context.Background()
+	a.handle(ctx, record, sampled)
+}
+
+// handle applies the configured processing to the record and passes it to the underlying handler, or to the dropped
+// sink if the record was not sampled.
+func (a *adapter) handle(ctx context.Context, record slog.Record, sampled bool) {
+	if !sampled {
+		a.drop(ctx, record)
+
+		return
+	}
+
+	if len(a.extractors) > 0 {
+		record.AddAttrs(a.extract(ctx)...)
 	}
 
-	_ = a.logger.Handler().Handle(ctx, record)
+	for _, fn := range a.hooks {
+		fn(ctx, &record)
+	}
+
+	for _, fn := range a.filters {
+		if !fn(ctx, &record) {
+			if a.dropped != nil {
+				a.drop(ctx, record)
+			}
+
+			return
+		}
+	}
+
+	if err := a.logger.Handler().Handle(ctx, record); err != nil {
+		a.report(err)
+	}
+
+	for _, fn := range a.emitted {
+		fn(ctx, record)
+	}
+
+	if a.flushLevel != nil && a.flusher != nil && record.Level >= *a.flushLevel {
+		if err := a.flusher.Flush(); err != nil {
+			a.report(err)
+		}
+	}
+}
+
+// background returns the context used in place of a nil context, reporting ErrNilContext if WithWarnOnNilContext is
+// set.
+func (a *adapter) background() context.Context {
+	if a.warnNilCtx {
+		a.report(ErrNilContext)
+	}
+
+	return context.Background()
+}
+
+// report passes the error, if not nil, to the configured error handler, if any.
+func (a *adapter) report(err error) {
+	if err != nil && a.onError != nil {
+		a.onError(err)
+	}
 }
 
 func Default() Adapter {
-	a := store.Load().(Adapter)
-	if a == nil {
+	a, ok := store.Load().(Adapter)
+	if !ok {
 		a = New(WithSetDefault())
 	}
 
@@ -178,6 +443,19 @@ func SetDefault(adapter Adapter) {
 	store.Store(adapter)
 }
 
+// SetGlobalAttrs sets the attributes added to every adapter created by New afterwards. Adapters created before are not
+// affected. The global attributes come before those given with WithAttrs. It is safe for concurrent use.
+func SetGlobalAttrs(attrs ...slog.Attr) {
+	globals.Store(append([]slog.Attr(nil), attrs...))
+}
+
+// GlobalAttrs returns a copy of the attributes set with SetGlobalAttrs.
+func GlobalAttrs() []slog.Attr {
+	attrs, _ := globals.Load().([]slog.Attr)
+
+	return append([]slog.Attr(nil), attrs...)
+}
+
 // New returns a new Adapter.
 func New(opts ...Option) Adapter {
 	a := &adapter{
@@ -189,21 +467,241 @@ func New(opts ...Option) Adapter {
 		opt(a)
 	}
 
+	a.build()
+
 	return a
 }
 
+// build wraps the handler of the logger according to the options.
+func (a *adapter) build() {
+	if a.console != nil {
+		a.logger = slog.New(newConsoleHandler(a.console, a.consoleOpts, a.consoleIcons))
+	}
+
+	handler := a.logger.Handler()
+
+	if flusher, ok := handler.(Flusher); ok {
+		a.flusher = flusher
+	}
+
+	if a.text != nil {
+		text := a.text
+		if len(a.textOmit) > 0 {
+			text = newOmitHandler(text, a.textOmit)
+		}
+
+		handler = &multiHandler{handlers: []slog.Handler{handler, text}}
+	}
+
+	if len(a.channels) > 0 {
+		handlers := []slog.Handler{handler}
+		for _, ch := range a.channels {
+			handlers = append(handlers, &channelHandler{ch: ch.ch, policy: ch.policy, primary: handler})
+		}
+
+		handler = &multiHandler{handlers: handlers}
+	}
+
+	if a.selector != nil {
+		handler = &selectorHandler{primary: handler, choose: a.selector}
+	}
+
+	if a.fallback != nil {
+		handler = &fallbackHandler{primary: handler, fallback: a.fallback}
+	}
+
+	if a.breakAfter > 0 {
+		handler = &breakerHandler{
+			primary:  handler,
+			fallback: slog.NewTextHandler(os.Stderr, nil),
+			state:    &breaker{threshold: a.breakAfter},
+		}
+	}
+
+	if a.bufferLevel != nil {
+		handler = &bufferHandler{next: handler, level: *a.bufferLevel, ring: newRing(a.bufferSize)}
+	}
+
+	if len(a.required) > 0 {
+		handler = &schemaHandler{next: handler, required: a.required}
+	}
+
+	if a.replay != nil {
+		handler = &captureHandler{next: handler, ring: a.replay.ring}
+	}
+
+	attrs := append(GlobalAttrs(), a.attrs...)
+	a.logger = slog.New(a.decorate(handler, attrs))
+
+	// the records dropped by the sampler go through the same rewrites and attributes as the records kept.
+	if a.dropped != nil {
+		a.dropped = slog.New(a.decorate(a.dropped.Handler(), attrs))
+	}
+}
+
+// decorate wraps the handler with the rewrites, the canonical order and the attributes added to every record.
+func (a *adapter) decorate(handler slog.Handler, attrs []slog.Attr) slog.Handler {
+	handler = a.rewrite(handler)
+
+	if a.canonical {
+		handler = &canonicalHandler{next: handler}
+	}
+
+	if len(attrs) > 0 {
+		handler = handler.WithAttrs(attrs)
+	}
+
+	return handler
+}
+
+// rewrite wraps the handler with the rewrites of the messages and of the attributes, redaction last, if any.
+func (a *adapter) rewrite(handler slog.Handler) slog.Handler {
+	var redact func(slog.Attr) slog.Attr
+	if len(a.redact) > 0 {
+		redact = a.redactValue
+	}
+
+	if len(a.message) == 0 && len(a.replacer) == 0 && len(a.topLevel) == 0 && redact == nil {
+		return handler
+	}
+
+	return &rewriteHandler{next: handler, message: a.message, replacer: a.replacer, topLevel: a.topLevel, redact: redact}
+}
+
 func WithLogger(logger *slog.Logger) Option {
 	return func(a *adapter) {
 		a.logger = logger
 	}
 }
 
+// WithAttrs adds the attributes to every record logged by the adapter, after the global attributes set with
+// SetGlobalAttrs.
+func WithAttrs(attrs ...slog.Attr) Option {
+	return func(a *adapter) {
+		a.attrs = append(a.attrs, attrs...)
+	}
+}
+
+// WithOrigin adds the name of the service as `origin`, with the host name as `host` and the process id as `pid`, to
+// every record, so that the records of the services writing to one aggregated stream can be told apart. The host is
+// omitted if its name cannot be determined.
+func WithOrigin(service string) Option {
+	return func(a *adapter) {
+		a.attrs = append(a.attrs, slog.String(OriginKey, service))
+
+		if host, err := os.Hostname(); err == nil {
+			a.attrs = append(a.attrs, slog.String(HostKey, host))
+		}
+
+		a.attrs = append(a.attrs, slog.Int(PIDKey, os.Getpid()))
+	}
+}
+
 func WithCallDepth(depth int) Option {
 	return func(a *adapter) {
 		a.depth = depth
 	}
 }
 
+// WithErrorHandler sets the function called when a record could not be handled. By default, errors are discarded.
+func WithErrorHandler(fn func(err error)) Option {
+	return func(a *adapter) {
+		a.onError = fn
+	}
+}
+
+// WithAsyncErrors returns an option delivering the errors passed to the error handler on the returned channel as
+// well, for consumers preferring a channel to a callback. The channel holds up to 64 errors; the errors reported while
+// it is full are dropped rather than blocking the logging call. It must come after WithErrorHandler, which replaces
+// the error handler.
+func WithAsyncErrors() (Option, <-chan error) {
+	errs := make(chan error, asyncErrors)
+
+	return func(a *adapter) {
+		previous := a.onError
+
+		a.onError = func(err error) {
+			if previous != nil {
+				previous(err)
+			}
+
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+	}, errs
+}
+
+// WithFallbackHandler sets a handler that is given the record when the underlying handler fails to handle it, e.g.
+// a local stderr handler for when a network sink is unavailable. If both fail, the error handler is called.
+func WithFallbackHandler(h slog.Handler) Option {
+	return func(a *adapter) {
+		a.fallback = h
+	}
+}
+
+// WithStderrFallback logs the records the underlying handler fails to handle to stderr, in the format of
+// slog.TextHandler, e.g. during an outage of a network sink, so that they are not lost. Once it failed
+// failureThreshold times in a row, the records go straight to stderr and only one in every failureThreshold is tried
+// on the underlying handler first; they go back to it as soon as one succeeds. The error of the failure switching to
+// stderr is reported to the error handler.
+func WithStderrFallback(failureThreshold int) Option {
+	return func(a *adapter) {
+		a.breakAfter = max(failureThreshold, 1)
+	}
+}
+
+// WithWarnOnNilContext reports ErrNilContext to the error handler whenever a nil context is passed to the adapter,
+// which is usually a bug. The record is still logged, using context.Background.
+func WithWarnOnNilContext() Option {
+	return func(a *adapter) {
+		a.warnNilCtx = true
+	}
+}
+
+// WithFlushMinLevel flushes the underlying handler after every record at or above the given level, if the handler
+// implements Flusher, e.g. to write out buffered records as soon as an error is logged.
+func WithFlushMinLevel(level slog.Level) Option {
+	return func(a *adapter) {
+		a.flushLevel = &level
+	}
+}
+
+// WithStrictAttrs turns the methods taking ...any, e.g. Info, into no-ops reporting ErrStrictAttrs, leaving LogAttrs
+// as the only way to log. This helps teams standardizing on typed attributes.
+func WithStrictAttrs() Option {
+	return func(a *adapter) {
+		a.strictAttrs = true
+	}
+}
+
+// WithHook adds a hook called with every record logged, after the attributes of the context are extracted. Hooks are
+// called in the order they were added, and the changes they make to the record are seen by the handler unless
+// WithHookRecordIsolation is set.
+func WithHook(fn Hook) Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(ctx context.Context, record *slog.Record) {
+			if a.isolateHooks {
+				clone := record.Clone()
+				fn(ctx, &clone)
+
+				return
+			}
+
+			fn(ctx, record)
+		})
+	}
+}
+
+// WithHookRecordIsolation passes a copy of the record to the hooks added with WithHook, so that they can observe it
+// but cannot change the record passed to the handler.
+func WithHookRecordIsolation() Option {
+	return func(a *adapter) {
+		a.isolateHooks = true
+	}
+}
+
 func WithSetDefault() Option {
 	return func(a *adapter) {
 		SetDefault(a)
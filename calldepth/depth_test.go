@@ -0,0 +1,56 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestLogAttrsDisabledAllocs(t *testing.T) {
+	a := New(WithLogger(slog.New(slog.NewJSONHandler(io.Discard, nil))))
+	ctx := context.Background()
+
+	// through the interface, the variadic slice escapes at the call site, whatever the adapter does with it.
+	concrete, _ := a.(*adapter)
+
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"adapter", func() {
+			concrete.LogAttrs(ctx, slog.LevelDebug, "m", slog.String("key", "value"))
+		}},
+		{"guarded", func() {
+			if a.Enabled(ctx, slog.LevelDebug) {
+				a.LogAttrs(ctx, slog.LevelDebug, "m", slog.String("key", "value"))
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allocs := testing.AllocsPerRun(100, tt.fn); allocs != 0 {
+				t.Errorf("disabled LogAttrs allocates %v times, want 0", allocs)
+			}
+		})
+	}
+}
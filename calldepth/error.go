@@ -0,0 +1,181 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"reflect"
+	"strings"
+)
+
+type (
+	// fielder is implemented by errors exposing structured fields.
+	fielder interface {
+		Fields() []slog.Attr
+	}
+)
+
+const (
+	// ErrorKey is the key of the error attribute added by LogError and WithError.
+	ErrorKey = "error"
+
+	// ErrorMessageKey is the key of the message of an error with structured fields, in the error group.
+	ErrorMessageKey = "message"
+
+	// StackTraceKey is the key of the attribute holding the stack trace carried by an error.
+	StackTraceKey = "stacktrace"
+
+	// FingerprintKey is the key of the attribute added by WithFingerprint.
+	FingerprintKey = "fingerprint"
+
+	// RecordHashKey is the key of the attribute added by WithRecordHash.
+	RecordHashKey = "record_hash"
+)
+
+// LogError logs a record at error level with the error, and the stack trace it carries if any, as attributes. With
+// WithStrictAttrs, the record is logged with the arguments that are a slog.Attr only.
+func LogError(ctx context.Context, a Adapter, err error, msg string, args ...any) {
+	impl := adapterOf(a)
+	impl.logattrs(ctx, slog.LevelError, msg, append(impl.argsAttrs(args), errorAttrs(err)...)...)
+}
+
+// WithError returns an adapter logging the error, and the stack trace it carries if any, with every record.
+func WithError(a Adapter, err error) Adapter {
+	return a.With(attrsToArgs(errorAttrs(err))...)
+}
+
+// FromError logs a record at the given level with the message of the error as its message and the fields of the error
+// as its attributes, if an error of the chain has a `Fields() []slog.Attr` method, for an error-first logging style.
+// The stack trace carried by the error, if any, is added as well. Nothing is logged if err is nil.
+func FromError(ctx context.Context, a Adapter, err error, level slog.Level) {
+	if err == nil {
+		return
+	}
+
+	var attrs []slog.Attr
+
+	var f fielder
+	if errors.As(err, &f) {
+		attrs = f.Fields()
+	}
+
+	if stack, ok := stackTrace(err); ok {
+		attrs = append(attrs[:len(attrs):len(attrs)], slog.String(StackTraceKey, stack))
+	}
+
+	adapterOf(a).logattrs(ctx, level, err.Error(), attrs...)
+}
+
+// WithFingerprint adds the fingerprint computed by fn, e.g. DefaultFingerprint, to every record, so that error
+// aggregation systems can group the occurrences of similar records.
+func WithFingerprint(fn func(r slog.Record) string) Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			record.AddAttrs(slog.String(FingerprintKey, fn(*record)))
+		})
+	}
+}
+
+// DefaultFingerprint returns a hash of the message and of the types of the errors among the attributes of the record,
+// so that records logged at the same place for the same kind of failure share a fingerprint.
+func DefaultFingerprint(r slog.Record) string {
+	hash := fnv.New64a()
+
+	_, _ = hash.Write([]byte(r.Message))
+
+	r.Attrs(func(attr slog.Attr) bool {
+		if err, ok := attr.Value.Resolve().Any().(error); ok {
+			_, _ = fmt.Fprintf(hash, "\x00%s=%T", attr.Key, err)
+		}
+
+		return true
+	})
+
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// WithRecordHash adds a hash of the message and of the attributes of every record, sorted by key, so that downstream
+// storage can deduplicate the records logged again by retries. Identical content hashes the same across runs; the time,
+// the level and the attributes added with With are not hashed.
+func WithRecordHash() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			record.AddAttrs(slog.String(RecordHashKey, recordHash(*record)))
+		})
+	}
+}
+
+// recordHash returns the hash of the message and of the sorted attributes of the record.
+func recordHash(r slog.Record) string {
+	hash := fnv.New64a()
+
+	_, _ = hash.Write([]byte(r.Message))
+
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+
+	r.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+
+		return true
+	})
+
+	for _, attr := range sortAttrs(attrs) {
+		_, _ = fmt.Fprintf(hash, "\x00%s=%s", attr.Key, attr.Value)
+	}
+
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// errorAttrs returns the attributes describing the error. If an error of the chain has a `Fields() []slog.Attr`
+// method, the error is logged as a group of its message and of the fields.
+func errorAttrs(err error) []slog.Attr {
+	attrs := []slog.Attr{slog.Any(ErrorKey, err)}
+
+	var f fielder
+	if errors.As(err, &f) {
+		fields := append([]slog.Attr{slog.String(ErrorMessageKey, err.Error())}, f.Fields()...)
+		attrs[0] = slog.Attr{Key: ErrorKey, Value: slog.GroupValue(fields...)}
+	}
+
+	if stack, ok := stackTrace(err); ok {
+		attrs = append(attrs, slog.String(StackTraceKey, stack))
+	}
+
+	return attrs
+}
+
+// stackTrace returns the stack trace of the first error in the chain with a StackTrace method, as provided by e.g.
+// github.com/pkg/errors. The method is looked up by name so that the error packages need not be imported.
+func stackTrace(err error) (string, bool) {
+	for err != nil {
+		method := reflect.ValueOf(err).MethodByName("StackTrace")
+		if method.IsValid() && method.Type().NumIn() == 0 && method.Type().NumOut() == 1 {
+			return strings.TrimSpace(fmt.Sprintf("%+v", method.Call(nil)[0].Interface())), true
+		}
+
+		err = errors.Unwrap(err)
+	}
+
+	return "", false
+}
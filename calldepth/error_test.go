@@ -0,0 +1,204 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// stackError carries a stack trace the way github.com/pkg/errors does.
+	stackError struct {
+		msg string
+	}
+
+	// stack is the stack trace of a stackError.
+	stack []string
+
+	// fieldsError exposes structured fields.
+	fieldsError struct{}
+)
+
+func (e *fieldsError) Error() string { return "not found" }
+
+func (e *fieldsError) Fields() []slog.Attr {
+	return []slog.Attr{slog.String("table", "users"), slog.Int("id", 42)}
+}
+
+func (e *stackError) Error() string { return e.msg }
+
+func (e *stackError) StackTrace() stack { return stack{"main.run", "main.main"} }
+
+func (s stack) Format(f fmt.State, _ rune) {
+	for _, frame := range s {
+		_, _ = fmt.Fprintf(f, "\n%s", frame)
+	}
+}
+
+func TestLogError(t *testing.T) {
+	a, buf := newJSON()
+	err := fmt.Errorf("sync: %w", &stackError{msg: "unavailable"})
+
+	_, _, line, _ := runtime.Caller(0)
+	calldepth.LogError(context.Background(), a, err, "failed", "user", "alice")
+
+	record := single(t, buf)
+	if record["level"] != "ERROR" || record[calldepth.ErrorKey] != "sync: unavailable" || record["user"] != "alice" {
+		t.Errorf("record = %v, want the error at error level", record)
+	}
+
+	if record[calldepth.StackTraceKey] != "main.run\nmain.main" {
+		t.Errorf("stacktrace = %q, want the stack trace of the wrapped error", record[calldepth.StackTraceKey])
+	}
+
+	if got := sourceLine(t, record); got != line+1 {
+		t.Errorf("source line = %d, want %d", got, line+1)
+	}
+}
+
+func TestLogErrorStrictAttrs(t *testing.T) {
+	var errs []error
+
+	a, buf := newJSON(calldepth.WithStrictAttrs(), calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	calldepth.LogError(context.Background(), a, errors.New("unavailable"), "failed", slog.String("user", "alice"), "tenant", "acme")
+
+	record := single(t, buf)
+	if record[calldepth.ErrorKey] != "unavailable" || record["user"] != "alice" {
+		t.Errorf("record = %v, want the error and the attributes logged", record)
+	}
+
+	if _, ok := record["tenant"]; ok {
+		t.Errorf("record = %v, want the key-value pair left out", record)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], calldepth.ErrStrictAttrs) {
+		t.Errorf("errors = %v, want ErrStrictAttrs for the key-value pair", errs)
+	}
+}
+
+func TestWithError(t *testing.T) {
+	a, buf := newJSON()
+
+	calldepth.WithError(a, &stackError{msg: "unavailable"}).Info("m")
+
+	record := single(t, buf)
+	if record[calldepth.ErrorKey] != "unavailable" || record[calldepth.StackTraceKey] != "main.run\nmain.main" {
+		t.Errorf("record = %v, want the error and its stack trace", record)
+	}
+}
+
+func TestWithErrorWithoutStackTrace(t *testing.T) {
+	a, buf := newJSON()
+
+	calldepth.WithError(a, errors.New("unavailable")).Info("m")
+
+	if _, ok := single(t, buf)[calldepth.StackTraceKey]; ok {
+		t.Errorf("stacktrace logged for an error without one: %s", buf)
+	}
+}
+
+func TestWithFingerprint(t *testing.T) {
+	a, buf := newJSON(calldepth.WithFingerprint(calldepth.DefaultFingerprint))
+
+	a.Error("sync failed", "error", &stackError{msg: "timeout after 3s"}, "user", "alice")
+	a.Error("sync failed", "error", &stackError{msg: "timeout after 5s"}, "user", "bob")
+	a.Error("sync failed", "error", errors.New("timeout after 3s"))
+
+	out := records(t, buf)
+	if len(out) != 3 {
+		t.Fatalf("got %d records, want 3", len(out))
+	}
+
+	similar, other := out[0][calldepth.FingerprintKey], out[2][calldepth.FingerprintKey]
+	if similar == nil || similar != out[1][calldepth.FingerprintKey] {
+		t.Errorf("fingerprints = %v and %v, want the same for similar errors", similar, out[1][calldepth.FingerprintKey])
+	}
+
+	if other == similar {
+		t.Errorf("fingerprint = %v, want another one for another type of error", other)
+	}
+}
+
+func TestWithErrorFields(t *testing.T) {
+	a, buf := newJSON()
+
+	calldepth.WithError(a, fmt.Errorf("lookup: %w", &fieldsError{})).Info("m")
+
+	group, _ := single(t, buf)[calldepth.ErrorKey].(map[string]any)
+	if group[calldepth.ErrorMessageKey] != "lookup: not found" || group["table"] != "users" || group["id"] != float64(42) {
+		t.Errorf("error = %v, want the message and the fields of the error", group)
+	}
+}
+
+func TestFromError(t *testing.T) {
+	a, buf := newJSON()
+
+	_, _, line, _ := runtime.Caller(0)
+	calldepth.FromError(context.Background(), a, fmt.Errorf("lookup: %w", &fieldsError{}), slog.LevelWarn)
+	calldepth.FromError(context.Background(), a, nil, slog.LevelWarn)
+
+	record := single(t, buf)
+	if record["level"] != "WARN" || record["msg"] != "lookup: not found" || record["table"] != "users" || record["id"] != float64(42) {
+		t.Errorf("record = %v, want the message and the fields of the error at warn level", record)
+	}
+
+	if got := sourceLine(t, record); got != line+1 {
+		t.Errorf("source line = %d, want %d", got, line+1)
+	}
+}
+
+func TestWithRecordHash(t *testing.T) {
+	a, buf := newJSON(calldepth.WithRecordHash())
+
+	a.Info("charged", "user", "alice", "amount", 10)
+	a.With("request", "r2").Info("charged", "amount", 10, "user", "alice")
+	a.Info("charged", "user", "bob", "amount", 10)
+	a.Info("charged")
+
+	out := records(t, buf)
+	if len(out) != 4 {
+		t.Fatalf("got %d records, want 4", len(out))
+	}
+
+	hashes := make([]string, len(out))
+	for i, record := range out {
+		hashes[i], _ = record[calldepth.RecordHashKey].(string)
+	}
+
+	if hashes[0] == "" || hashes[0] != hashes[1] {
+		t.Errorf("hashes = %q, %q, want identical records to hash the same", hashes[0], hashes[1])
+	}
+
+	if hashes[0] == hashes[2] {
+		t.Errorf("hash = %q for records with different attributes, want different hashes", hashes[2])
+	}
+
+	// the 64-bit FNV-1a hash of the message, the same in every run.
+	if want := "ef8897783be7abef"; hashes[3] != want {
+		t.Errorf("hash = %q, want %q", hashes[3], want)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+var (
+	exitMu       sync.Mutex
+	exitFlushers []Flusher
+)
+
+// Flush flushes the underlying handler, if it implements Flusher.
+func (a *adapter) Flush() error {
+	if a.flusher == nil {
+		return nil
+	}
+
+	return a.flusher.Flush()
+}
+
+// RegisterFlushOnExit registers the adapter to be flushed by FlushRegistered and Exit, e.g. from init, so that the
+// records logged by a program exiting early are not lost in a buffer.
+//
+// Go has no atexit hook: neither os.Exit nor a crash run deferred functions or finalizers, so flushing on exit is best
+// effort. Defer FlushRegistered in main and call Exit instead of os.Exit for the registered adapters to be flushed.
+func RegisterFlushOnExit(a Adapter) {
+	flusher, ok := a.(Flusher)
+	if !ok {
+		return
+	}
+
+	exitMu.Lock()
+	defer exitMu.Unlock()
+
+	exitFlushers = append(exitFlushers, flusher)
+}
+
+// FlushRegistered flushes the adapters registered with RegisterFlushOnExit, in the order they were registered.
+func FlushRegistered() error {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+
+	errs := make([]error, 0, len(exitFlushers))
+	for _, flusher := range exitFlushers {
+		errs = append(errs, flusher.Flush())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Exit flushes the adapters registered with RegisterFlushOnExit and exits the program with the given code.
+func Exit(code int) {
+	_ = FlushRegistered()
+
+	os.Exit(code)
+}
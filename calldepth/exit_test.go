@@ -0,0 +1,40 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestRegisterFlushOnExit(t *testing.T) {
+	h := &flushingHandler{Handler: slog.NewJSONHandler(io.Discard, nil)}
+	calldepth.RegisterFlushOnExit(calldepth.New(calldepth.WithLogger(slog.New(h))))
+
+	if err := calldepth.FlushRegistered(); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.flushed != 1 {
+		t.Errorf("flushed %d times, want 1", h.flushed)
+	}
+}
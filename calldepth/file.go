@@ -0,0 +1,114 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+type (
+	// FileWriter is an io.Writer appending to a file that can be reopened, e.g. after logrotate renamed it, without
+	// losing writes. It is safe for concurrent use.
+	FileWriter struct {
+		mu   sync.Mutex
+		path string
+		file *os.File
+	}
+)
+
+const (
+	// fileMode is the permission of the files created by FileWriter.
+	fileMode = 0o644
+)
+
+// OpenFile returns a FileWriter appending to the file at path, creating it if needed.
+func OpenFile(path string) (*FileWriter, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileWriter{path: path, file: file}, nil
+}
+
+func (w *FileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Write(p)
+}
+
+// Reopen opens the file at the path again and closes the previous one, so that writes go to a new file once the
+// previous one was renamed. If the file cannot be opened, writes keep going to the previous one.
+func (w *FileWriter) Reopen() error {
+	file, err := openFile(w.path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	previous := w.file
+	w.file = file
+	w.mu.Unlock()
+
+	return previous.Close()
+}
+
+// ReopenOnSignal reopens the file every time the process receives sig, usually syscall.SIGHUP sent by logrotate,
+// until stop is called. Errors reopening the file are passed to onError, if not nil.
+func (w *FileWriter) ReopenOnSignal(sig os.Signal, onError func(err error)) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+
+	signal.Notify(signals, sig)
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := w.Reopen(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// Close closes the file.
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// openFile opens the file at path for appending, creating it if needed.
+func openFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, fileMode) //nolint:gosec // the path is the caller's.
+}
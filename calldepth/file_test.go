@@ -0,0 +1,118 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+// content returns the content of the file at path.
+func content(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// openFile returns a FileWriter on a file in a temporary directory, and the path of the file.
+func openFile(t *testing.T) (*calldepth.FileWriter, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	w, err := calldepth.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = w.Close() })
+
+	return w, path
+}
+
+func TestFileWriterReopen(t *testing.T) {
+	w, path := openFile(t)
+
+	_, _ = w.Write([]byte("before\n"))
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = w.Write([]byte("renamed\n"))
+
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = w.Write([]byte("after\n"))
+
+	if got := content(t, path+".1"); got != "before\nrenamed\n" {
+		t.Errorf("rotated file = %q, want the writes before the reopen", got)
+	}
+
+	if got := content(t, path); got != "after\n" {
+		t.Errorf("file = %q, want the writes after the reopen", got)
+	}
+}
+
+func TestFileWriterReopenOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals cannot be sent to the process on windows")
+	}
+
+	w, path := openFile(t)
+
+	stop := w.ReopenOnSignal(syscall.SIGHUP, func(err error) { t.Error(err) })
+	defer stop()
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("file not reopened after the signal")
+		}
+	}
+}
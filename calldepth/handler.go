@@ -0,0 +1,581 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+type (
+	// fallbackHandler passes records to the fallback handler when the primary handler fails.
+	fallbackHandler struct {
+		primary  slog.Handler
+		fallback slog.Handler
+	}
+
+	// breakerHandler passes the records the primary handler fails to handle to the fallback handler, and stops trying
+	// the primary handler while it keeps failing.
+	breakerHandler struct {
+		primary  slog.Handler
+		fallback slog.Handler
+		state    *breaker
+	}
+
+	// breaker counts the consecutive failures of the primary handler of a breakerHandler and its derived handlers.
+	breaker struct {
+		mu        sync.Mutex
+		threshold int
+		failures  int
+		open      bool // open is set while the records go to the fallback handler.
+		skipped   int  // skipped is the number of records not tried on the primary handler since it was last tried.
+	}
+
+	// rewriteHandler rewrites the message and the attributes of records, including those added with WithAttrs, before
+	// passing them to the next handler.
+	rewriteHandler struct {
+		next     slog.Handler
+		message  []func(msg string) string
+		replacer []func(attr slog.Attr) slog.Attr
+		topLevel []func(attr slog.Attr) slog.Attr // topLevel rewrites the attributes out of groups only, after replacer.
+		redact   func(attr slog.Attr) slog.Attr   // redact rewrites every attribute last, if set.
+	}
+
+	// selectorHandler passes each record to the handler chosen for it, or to the primary handler.
+	selectorHandler struct {
+		primary slog.Handler
+		choose  func(r slog.Record) slog.Handler
+		derive  []func(h slog.Handler) slog.Handler // derive replays WithAttrs and WithGroup on the chosen handler.
+		cache   *sync.Map                           // cache holds the chosen handlers derived, by chosen handler.
+	}
+
+	// levelHandler is the handler of the records at or above a level, for WithLevelHandlerOptions.
+	levelHandler struct {
+		level   slog.Level
+		handler slog.Handler
+	}
+
+	// canonicalHandler passes records to the next handler with their attributes, including those added with WithAttrs
+	// and the groups opened with WithGroup, sorted by key.
+	canonicalHandler struct {
+		next   slog.Handler
+		derive []folded
+	}
+
+	// multiHandler passes each record to all of its handlers that are enabled for it.
+	multiHandler struct {
+		handlers []slog.Handler
+	}
+
+	// omitHandler removes the attributes with the given keys, including from groups, before passing records to the
+	// next handler.
+	omitHandler struct {
+		next slog.Handler
+		keys map[string]bool
+	}
+)
+
+// WithDualHandler passes every record to the text handler too, on top of the handler of the logger, usually a JSON
+// handler, e.g. to write human readable logs to the console next to the structured logs.
+func WithDualHandler(text slog.Handler) Option {
+	return func(a *adapter) {
+		a.text = text
+	}
+}
+
+// WithTextOmitKeys removes the attributes with the given keys from the records passed to the text handler set with
+// WithDualHandler, while keeping them for the handler of the logger.
+func WithTextOmitKeys(keys ...string) Option {
+	return func(a *adapter) {
+		a.textOmit = append(a.textOmit, keys...)
+	}
+}
+
+// WithHandlerSelector passes each record to the handler returned by fn, e.g. audit records to an audit sink, or to the
+// underlying handler if fn returns nil. The attributes and groups added to the adapter are applied to the chosen
+// handler for every record it is chosen for.
+func WithHandlerSelector(fn func(r slog.Record) slog.Handler) Option {
+	return func(a *adapter) {
+		a.selector = fn
+	}
+}
+
+// WithCanonicalOrder logs the attributes of every record sorted by key, after the time, level, source and message
+// placed first by the handler, for output that diffs cleanly between runs. The attributes added with With are sorted
+// along with those of the record, and the members of groups with each other.
+func WithCanonicalOrder() Option {
+	return func(a *adapter) {
+		a.canonical = true
+	}
+}
+
+// WithLevelHandlerOptions passes the records at or above each level of opts to a handler created by newHandler with
+// the options of the level, e.g. slog.NewJSONHandler with AddSource set for slog.LevelError, and the records below
+// all of them to the underlying handler. Whether a record is logged is still decided by the underlying handler.
+// It is built on WithHandlerSelector, which it comes after; the handler chosen by that selector takes precedence.
+func WithLevelHandlerOptions(newHandler func(opts *slog.HandlerOptions) slog.Handler, opts map[slog.Level]*slog.HandlerOptions) Option {
+	handlers := make([]levelHandler, 0, len(opts))
+	for level, o := range opts {
+		handlers = append(handlers, levelHandler{level: level, handler: newHandler(o)})
+	}
+
+	// the highest level first, for the first match to be the closest.
+	slices.SortFunc(handlers, func(x, y levelHandler) int { return cmp.Compare(y.level, x.level) })
+
+	return func(a *adapter) {
+		previous := a.selector
+
+		a.selector = func(r slog.Record) slog.Handler {
+			if previous != nil {
+				if chosen := previous(r); chosen != nil {
+					return chosen
+				}
+			}
+
+			for _, h := range handlers {
+				if r.Level >= h.level {
+					return h.handler
+				}
+			}
+
+			return nil
+		}
+	}
+}
+
+// WithSourceOnlyForErrors keeps the source of the records at error level and above only, for a handler created with
+// AddSource, so that the cost of formatting the source is only paid for the records that need it. Records below
+// error are handled without a program counter.
+func WithSourceOnlyForErrors() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			if record.Level < slog.LevelError {
+				record.PC = 0
+			}
+		})
+	}
+}
+
+func (h *fallbackHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.primary.Enabled(ctx, level)
+}
+
+func (h *fallbackHandler) Handle(ctx context.Context, record slog.Record) error {
+	err := h.primary.Handle(ctx, record.Clone())
+	if err == nil {
+		return nil
+	}
+
+	if ferr := h.fallback.Handle(ctx, record); ferr != nil {
+		return errors.Join(err, ferr)
+	}
+
+	return nil
+}
+
+func (h *fallbackHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &fallbackHandler{primary: h.primary.WithAttrs(attrs), fallback: h.fallback.WithAttrs(attrs)}
+}
+
+func (h *fallbackHandler) WithGroup(name string) slog.Handler {
+	return &fallbackHandler{primary: h.primary.WithGroup(name), fallback: h.fallback.WithGroup(name)}
+}
+
+func (h *breakerHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.primary.Enabled(ctx, level)
+}
+
+func (h *breakerHandler) Handle(ctx context.Context, record slog.Record) error {
+	if !h.state.try() {
+		return h.fallback.Handle(ctx, record)
+	}
+
+	err := h.primary.Handle(ctx, record.Clone())
+
+	tripped := h.state.done(err == nil)
+	if err == nil {
+		return nil
+	}
+
+	// every failed record goes to the fallback handler, the threshold only decides when to stop trying the primary.
+	if ferr := h.fallback.Handle(ctx, record); ferr != nil {
+		return errors.Join(err, ferr)
+	}
+
+	if tripped {
+		return err
+	}
+
+	return nil
+}
+
+func (h *breakerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &breakerHandler{primary: h.primary.WithAttrs(attrs), fallback: h.fallback.WithAttrs(attrs), state: h.state}
+}
+
+func (h *breakerHandler) WithGroup(name string) slog.Handler {
+	return &breakerHandler{primary: h.primary.WithGroup(name), fallback: h.fallback.WithGroup(name), state: h.state}
+}
+
+// try reports whether the next record is tried on the primary handler: always while the breaker is closed, and one
+// record in every threshold while it is open.
+func (b *breaker) try() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true
+	}
+
+	b.skipped++
+	if b.skipped < b.threshold {
+		return false
+	}
+
+	b.skipped = 0
+
+	return true
+}
+
+// done records the outcome of a record tried on the primary handler, and reports whether it opened the breaker.
+func (b *breaker) done(ok bool) (tripped bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		b.failures, b.open = 0, false
+
+		return false
+	}
+
+	b.failures++
+	tripped = !b.open && b.failures >= b.threshold
+	b.open = b.open || tripped
+
+	return tripped
+}
+
+func (h *rewriteHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *rewriteHandler) Handle(ctx context.Context, record slog.Record) error {
+	msg := record.Message
+	for _, fn := range h.message {
+		msg = fn(msg)
+	}
+
+	rewritten := slog.NewRecord(record.Time, record.Level, msg, record.PC)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		rewritten.AddAttrs(h.replace(attr, false))
+
+		return true
+	})
+
+	return h.next.Handle(ctx, rewritten)
+}
+
+func (h *rewriteHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	replaced := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		replaced[i] = h.replace(attr, false)
+	}
+
+	c := *h
+	c.next = h.next.WithAttrs(replaced)
+
+	return &c
+}
+
+func (h *rewriteHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+
+	return &c
+}
+
+// replace applies the replacers to the attribute, and to the members of group attributes, the top level replacers
+// unless the attribute is nested in a group, and redaction last.
+func (h *rewriteHandler) replace(attr slog.Attr, nested bool) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+
+	if attr.Value.Kind() == slog.KindGroup {
+		members := attr.Value.Group()
+		replaced := make([]slog.Attr, len(members))
+
+		for i, member := range members {
+			replaced[i] = h.replace(member, true)
+		}
+
+		attr.Value = slog.GroupValue(replaced...)
+	}
+
+	for _, fn := range h.replacer {
+		attr = fn(attr)
+	}
+
+	if !nested {
+		for _, fn := range h.topLevel {
+			attr = fn(attr)
+		}
+	}
+
+	if h.redact != nil {
+		attr = h.redact(attr)
+	}
+
+	return attr
+}
+
+func (h *selectorHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.primary.Enabled(ctx, level)
+}
+
+func (h *selectorHandler) Handle(ctx context.Context, record slog.Record) error {
+	chosen := h.choose(record)
+	if chosen == nil {
+		return h.primary.Handle(ctx, record)
+	}
+
+	return h.derivedFrom(chosen).Handle(ctx, record)
+}
+
+// derivedFrom returns the chosen handler with the calls to WithAttrs and WithGroup replayed, derived once per chosen
+// handler. Handlers of a type that is not comparable are derived for every record.
+func (h *selectorHandler) derivedFrom(chosen slog.Handler) slog.Handler {
+	if len(h.derive) == 0 {
+		return chosen
+	}
+
+	cacheable := reflect.TypeOf(chosen).Comparable()
+	if cacheable {
+		if cached, ok := h.cache.Load(chosen); ok {
+			derived, _ := cached.(slog.Handler)
+
+			return derived
+		}
+	}
+
+	derived := chosen
+	for _, derive := range h.derive {
+		derived = derive(derived)
+	}
+
+	if cacheable {
+		h.cache.Store(chosen, derived)
+	}
+
+	return derived
+}
+
+func (h *selectorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.derived(h.primary.WithAttrs(attrs), func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *selectorHandler) WithGroup(name string) slog.Handler {
+	return h.derived(h.primary.WithGroup(name), func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+// derived returns a selectorHandler with the given primary handler, applying derive to the chosen handlers.
+func (h *selectorHandler) derived(primary slog.Handler, derive func(slog.Handler) slog.Handler) *selectorHandler {
+	return &selectorHandler{
+		primary: primary,
+		choose:  h.choose,
+		derive:  append(h.derive[:len(h.derive):len(h.derive)], derive),
+		cache:   &sync.Map{},
+	}
+}
+
+func (h *canonicalHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *canonicalHandler) Handle(ctx context.Context, record slog.Record) error {
+	record = fold(record, h.derive)
+
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+
+		return true
+	})
+
+	sorted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	sorted.AddAttrs(sortAttrs(attrs)...)
+
+	return h.next.Handle(ctx, sorted)
+}
+
+func (h *canonicalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &canonicalHandler{next: h.next, derive: append(h.derive[:len(h.derive):len(h.derive)], folded{attrs: attrs})}
+}
+
+func (h *canonicalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &canonicalHandler{next: h.next, derive: append(h.derive[:len(h.derive):len(h.derive)], folded{group: name})}
+}
+
+// sortAttrs sorts the attributes by key, keeping the order of equal keys, and the members of groups recursively.
+// Groups with an empty key are inlined, as handlers would.
+func sortAttrs(attrs []slog.Attr) []slog.Attr {
+	sorted := make([]slog.Attr, 0, len(attrs))
+
+	for _, attr := range attrs {
+		attr.Value = attr.Value.Resolve()
+
+		if attr.Value.Kind() == slog.KindGroup && attr.Key == "" {
+			sorted = append(sorted, attr.Value.Group()...)
+
+			continue
+		}
+
+		sorted = append(sorted, attr)
+	}
+
+	slices.SortStableFunc(sorted, func(x, y slog.Attr) int { return cmp.Compare(x.Key, y.Key) })
+
+	for i, attr := range sorted {
+		if attr.Value.Kind() == slog.KindGroup {
+			sorted[i].Value = slog.GroupValue(sortAttrs(attr.Value.Group())...)
+		}
+	}
+
+	return sorted
+}
+
+func (h *multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *multiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+
+	for _, handler := range h.handlers {
+		if !handler.Enabled(ctx, record.Level) {
+			continue
+		}
+
+		if err := handler.Handle(ctx, record.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (h *multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+
+	return &multiHandler{handlers: handlers}
+}
+
+func (h *multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithGroup(name)
+	}
+
+	return &multiHandler{handlers: handlers}
+}
+
+// newOmitHandler returns a handler removing the attributes with the given keys before passing records to next.
+func newOmitHandler(next slog.Handler, keys []string) *omitHandler {
+	h := &omitHandler{next: next, keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		h.keys[key] = true
+	}
+
+	return h
+}
+
+func (h *omitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *omitHandler) Handle(ctx context.Context, record slog.Record) error {
+	kept := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr, ok := h.omit(attr); ok {
+			kept.AddAttrs(attr)
+		}
+
+		return true
+	})
+
+	return h.next.Handle(ctx, kept)
+}
+
+func (h *omitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	kept := make([]slog.Attr, 0, len(attrs))
+
+	for _, attr := range attrs {
+		if attr, ok := h.omit(attr); ok {
+			kept = append(kept, attr)
+		}
+	}
+
+	return &omitHandler{next: h.next.WithAttrs(kept), keys: h.keys}
+}
+
+func (h *omitHandler) WithGroup(name string) slog.Handler {
+	return &omitHandler{next: h.next.WithGroup(name), keys: h.keys}
+}
+
+// omit returns the attribute without the omitted members of groups, and false if the attribute itself is omitted.
+func (h *omitHandler) omit(attr slog.Attr) (slog.Attr, bool) {
+	if h.keys[attr.Key] {
+		return attr, false
+	}
+
+	value := attr.Value.Resolve()
+	if value.Kind() != slog.KindGroup {
+		return attr, true
+	}
+
+	members := make([]slog.Attr, 0, len(value.Group()))
+
+	for _, member := range value.Group() {
+		if member, ok := h.omit(member); ok {
+			members = append(members, member)
+		}
+	}
+
+	return slog.Attr{Key: attr.Key, Value: slog.GroupValue(members...)}, true
+}
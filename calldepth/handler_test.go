@@ -0,0 +1,321 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// failingHandler fails to handle every record, counting them.
+	failingHandler struct {
+		handled int
+	}
+
+	// derivingHandler counts the handlers derived from it with WithAttrs.
+	derivingHandler struct {
+		slog.Handler
+
+		derived *int
+	}
+)
+
+var errUnavailable = errors.New("unavailable")
+
+func (h *failingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *failingHandler) Handle(context.Context, slog.Record) error {
+	h.handled++
+
+	return errUnavailable
+}
+
+func (h *failingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *failingHandler) WithGroup(string) slog.Handler { return h }
+
+func (h *derivingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	*h.derived++
+
+	return &derivingHandler{Handler: h.Handler.WithAttrs(attrs), derived: h.derived}
+}
+
+// captureStderr redirects os.Stderr to a file for the duration of the test, and returns a function reading it.
+func captureStderr(t *testing.T) func() string {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := os.Stderr
+	os.Stderr = f
+
+	t.Cleanup(func() {
+		os.Stderr = stderr
+		_ = f.Close()
+	})
+
+	return func() string {
+		out, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(out)
+	}
+}
+
+func TestWithStderrFallback(t *testing.T) {
+	stderr := captureStderr(t)
+	primary := &failingHandler{}
+
+	var errs []error
+
+	a := calldepth.New(
+		calldepth.WithLogger(slog.New(primary)),
+		calldepth.WithStderrFallback(3),
+		calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+
+	for range [6]struct{}{} {
+		a.Info("m")
+	}
+
+	if lines := strings.Count(stderr(), "msg=m"); lines != 6 {
+		t.Errorf("got %d records on stderr, want all 6", lines)
+	}
+
+	if primary.handled != 4 {
+		t.Errorf("primary handled %d records, want 3 before the breaker opened and 1 probe", primary.handled)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], errUnavailable) {
+		t.Errorf("errors = %v, want the failure opening the breaker only", errs)
+	}
+}
+
+func TestWithFallbackHandler(t *testing.T) {
+	fallback, buf := newJSON()
+
+	var errs []error
+
+	a := calldepth.New(
+		calldepth.WithLogger(slog.New(&failingHandler{})),
+		calldepth.WithFallbackHandler(fallback.Handler()),
+		calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+
+	a.Info("m", "user", "alice")
+
+	if record := single(t, buf); record["msg"] != "m" || record["user"] != "alice" {
+		t.Errorf("record = %v, want the record failing on the primary handler", record)
+	}
+
+	if len(errs) != 0 {
+		t.Errorf("errors = %v, want none when the fallback handler succeeds", errs)
+	}
+}
+
+func TestWithFallbackHandlerFailing(t *testing.T) {
+	var errs []error
+
+	a := calldepth.New(
+		calldepth.WithLogger(slog.New(&failingHandler{})),
+		calldepth.WithFallbackHandler(&failingHandler{}),
+		calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+
+	a.Info("m")
+
+	if len(errs) != 1 || !errors.Is(errs[0], errUnavailable) {
+		t.Errorf("errors = %v, want the failure of both handlers", errs)
+	}
+}
+
+func TestWithHandlerSelector(t *testing.T) {
+	audit, auditBuf := newJSON()
+
+	a, buf := newJSON(calldepth.WithHandlerSelector(func(r slog.Record) slog.Handler {
+		var selected slog.Handler
+
+		r.Attrs(func(attr slog.Attr) bool {
+			if attr.Key == "audit" && attr.Value.Bool() {
+				selected = audit.Handler()
+			}
+
+			return selected == nil
+		})
+
+		return selected
+	}))
+
+	a.With("service", "api").Info("login", "audit", true)
+	a.Info("request")
+
+	if record := single(t, auditBuf); record["msg"] != "login" || record["service"] != "api" {
+		t.Errorf("audit record = %v, want the audit record with the attributes of the adapter", record)
+	}
+
+	if record := single(t, buf); record["msg"] != "request" {
+		t.Errorf("record = %v, want the other record on the primary handler", record)
+	}
+}
+
+func TestWithHandlerSelectorDerivedOnce(t *testing.T) {
+	var (
+		buf     bytes.Buffer
+		derived int
+	)
+
+	chosen := &derivingHandler{Handler: slog.NewJSONHandler(&buf, nil), derived: &derived}
+	a, _ := newJSON(calldepth.WithHandlerSelector(func(slog.Record) slog.Handler { return chosen }))
+
+	logger := a.With("service", "api")
+	for i := 0; i < 3; i++ {
+		logger.Info("m")
+	}
+
+	if derived != 1 {
+		t.Errorf("chosen handler derived %d times for 3 records, want once", derived)
+	}
+
+	for _, record := range records(t, &buf) {
+		if record["service"] != "api" {
+			t.Errorf("record = %v, want the attributes of the adapter", record)
+		}
+	}
+}
+
+func TestWithDualHandler(t *testing.T) {
+	var text bytes.Buffer
+
+	a, buf := newJSON(
+		calldepth.WithDualHandler(slog.NewTextHandler(&text, nil)),
+		calldepth.WithTextOmitKeys("trace_id"),
+	)
+
+	a.With("trace_id", "abc").Info("m", "user", "alice", slog.Group("req", "trace_id", "def", "path", "/"))
+
+	if record := single(t, buf); record["trace_id"] != "abc" || record["user"] != "alice" {
+		t.Errorf("json record = %v, want all the attributes", record)
+	}
+
+	out := text.String()
+	if !strings.Contains(out, "msg=m user=alice req.path=/") || strings.Contains(out, "trace_id") {
+		t.Errorf("text record = %q, want the record without the omitted keys", out)
+	}
+}
+
+func TestWithAsyncErrors(t *testing.T) {
+	var reported int
+
+	option, errs := calldepth.WithAsyncErrors()
+	a := calldepth.New(
+		calldepth.WithLogger(slog.New(&failingHandler{})),
+		calldepth.WithErrorHandler(func(error) { reported++ }),
+		option,
+	)
+
+	// more errors than the channel holds, the logging calls must not block.
+	for i := 0; i < 70; i++ {
+		a.Info("m")
+	}
+
+	if reported != 70 {
+		t.Errorf("error handler called %d times, want 70", reported)
+	}
+
+	if len(errs) != cap(errs) {
+		t.Fatalf("channel holds %d errors, want it full with %d", len(errs), cap(errs))
+	}
+
+	for len(errs) > 0 {
+		if err := <-errs; !errors.Is(err, errUnavailable) {
+			t.Errorf("error = %v, want %v", err, errUnavailable)
+		}
+	}
+}
+
+func TestWithLevelHandlerOptions(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := calldepth.New(
+		calldepth.WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		calldepth.WithLevelHandlerOptions(
+			func(opts *slog.HandlerOptions) slog.Handler { return slog.NewJSONHandler(&buf, opts) },
+			map[slog.Level]*slog.HandlerOptions{slog.LevelError: {AddSource: true}},
+		),
+	)
+
+	a.Info("m")
+	a.Error("m")
+
+	out := records(t, &buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	if _, ok := out[0][slog.SourceKey]; ok {
+		t.Errorf("info record = %v, want it handled without the source", out[0])
+	}
+
+	if _, ok := out[1][slog.SourceKey]; !ok {
+		t.Errorf("error record = %v, want it handled with the options of its level", out[1])
+	}
+}
+
+func TestWithSourceOnlyForErrors(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSourceOnlyForErrors())
+
+	a.Warn("m")
+	a.Error("m")
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	if _, ok := out[0][slog.SourceKey]; ok {
+		t.Errorf("warn record = %v, want no source", out[0])
+	}
+
+	if _, ok := out[1][slog.SourceKey]; !ok {
+		t.Errorf("error record = %v, want the source", out[1])
+	}
+}
+
+func TestWithCanonicalOrder(t *testing.T) {
+	a, buf := newJSON(calldepth.WithCanonicalOrder())
+
+	a.With("zeta", 1).Info("m", "beta", 2, slog.Group("g", "y", 1, "x", 2), "alpha", 3)
+
+	if want := `"msg":"m","alpha":3,"beta":2,"g":{"x":2,"y":1},"zeta":1}`; !strings.HasSuffix(strings.TrimSpace(buf.String()), want) {
+		t.Errorf("record = %s, want it ending with %s", buf, want)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// recorder keeps the records it handles.
+	recorder struct {
+		mu      sync.Mutex
+		records []slog.Record
+	}
+)
+
+// newRecorder returns an adapter logging to a recorder at all levels, and the recorder.
+func newRecorder(opts ...calldepth.Option) (calldepth.Adapter, *recorder) {
+	rec := &recorder{}
+
+	return calldepth.New(append([]calldepth.Option{calldepth.WithLogger(slog.New(rec))}, opts...)...), rec
+}
+
+func (r *recorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (r *recorder) Handle(_ context.Context, record slog.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, record.Clone())
+
+	return nil
+}
+
+func (r *recorder) WithAttrs([]slog.Attr) slog.Handler { return r }
+
+func (r *recorder) WithGroup(string) slog.Handler { return r }
+
+// newJSON returns an adapter logging to a JSON handler at debug level, and the buffer the handler writes to.
+func newJSON(opts ...calldepth.Option) (calldepth.Adapter, *bytes.Buffer) {
+	return newJSONAt(slog.LevelDebug, opts...)
+}
+
+// newJSONAt returns an adapter logging to a JSON handler at the given level, and the buffer the handler writes to.
+func newJSONAt(level slog.Level, opts ...calldepth.Option) (calldepth.Adapter, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: true, Level: level}))
+
+	return calldepth.New(append([]calldepth.Option{calldepth.WithLogger(logger)}, opts...)...), buf
+}
+
+// records returns the records written to buf by a JSON handler.
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var out []map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		record := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+
+		out = append(out, record)
+	}
+
+	return out
+}
+
+// single returns the only record written to buf by a JSON handler.
+func single(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+
+	out := records(t, buf)
+	if len(out) != 1 {
+		t.Fatalf("got %d records, want 1: %s", len(out), buf)
+	}
+
+	return out[0]
+}
+
+// sourceLine returns the line of the source of a record written by a JSON handler.
+func sourceLine(t *testing.T, record map[string]any) int {
+	t.Helper()
+
+	source, ok := record[slog.SourceKey].(map[string]any)
+	if !ok {
+		t.Fatalf("record has no source: %v", record)
+	}
+
+	line, _ := source["line"].(float64)
+
+	return int(line)
+}
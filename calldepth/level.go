@@ -0,0 +1,181 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type (
+	// verboseKey is the context key for the verbose flag.
+	verboseKey struct{}
+
+	// repeats counts the occurrences of messages within a window, for WithPromoteOnRepeat.
+	repeats struct {
+		mu     sync.Mutex
+		window time.Duration
+		seen   map[string]*repeat
+	}
+
+	// repeat is the number of occurrences of a message since the start of its window.
+	repeat struct {
+		start time.Time
+		count int
+	}
+)
+
+var (
+	// levelVars are the levels of the subsystems, by name, returned by NamedLevelVar.
+	levelVars sync.Map
+)
+
+const (
+	// maxTrackedKeys is the number of distinct keys, e.g. messages, that the options counting records per key track
+	// before evicting the expired ones.
+	maxTrackedKeys = 1024
+)
+
+// WithContextVerbose logs the records at or above verboseLevel, e.g. slog.LevelDebug, when the context is flagged
+// with ContextWithVerbose, even if the handler would not log them.
+func WithContextVerbose(verboseLevel slog.Level) Option {
+	return func(a *adapter) {
+		a.verboseLevel = &verboseLevel
+	}
+}
+
+// ContextWithVerbose returns a copy of the context flagged as verbose or not, e.g. when a request carries a debug
+// header.
+func ContextWithVerbose(ctx context.Context, verbose bool) context.Context {
+	return context.WithValue(ctx, verboseKey{}, verbose)
+}
+
+// IsVerbose reports whether the context is flagged as verbose with ContextWithVerbose.
+func IsVerbose(ctx context.Context) bool {
+	verbose, _ := ctx.Value(verboseKey{}).(bool)
+
+	return verbose
+}
+
+// WithPromoteOnRepeat logs a message at level to instead of from once it has been logged more than threshold times at
+// level from within the window, e.g. to surface a warning that keeps repeating as an error. The count of a message
+// starts over when its window expires.
+func WithPromoteOnRepeat(from, to slog.Level, threshold int, window time.Duration) Option {
+	return func(a *adapter) {
+		r := &repeats{window: window, seen: make(map[string]*repeat)}
+
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			if record.Level == from && r.count(record.Message, record.Time) > threshold {
+				record.Level = to
+			}
+		})
+	}
+}
+
+// count records an occurrence of the message at the given time and returns the number of occurrences in its window.
+func (r *repeats) count(msg string, now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.seen[msg]
+	if !ok {
+		if len(r.seen) >= maxTrackedKeys {
+			r.evict(now)
+		}
+
+		entry = &repeat{start: now}
+		r.seen[msg] = entry
+	}
+
+	if now.Sub(entry.start) > r.window {
+		entry.start = now
+		entry.count = 0
+	}
+
+	entry.count++
+
+	return entry.count
+}
+
+// evict removes the messages whose window expired, or all of them if none did, to keep memory bounded.
+func (r *repeats) evict(now time.Time) {
+	for msg, entry := range r.seen {
+		if now.Sub(entry.start) > r.window {
+			delete(r.seen, msg)
+		}
+	}
+
+	if len(r.seen) >= maxTrackedKeys {
+		r.seen = make(map[string]*repeat)
+	}
+}
+
+// NamedLevelVar returns the level of the subsystem with the given name, e.g. "db", created at info level on first use.
+// Setting it, e.g. from an admin endpoint, changes the level of the adapters created with WithSubsystemLevel(name) and
+// of those only.
+func NamedLevelVar(name string) *slog.LevelVar {
+	if v, ok := levelVars.Load(name); ok {
+		return v.(*slog.LevelVar)
+	}
+
+	v, _ := levelVars.LoadOrStore(name, new(slog.LevelVar))
+
+	return v.(*slog.LevelVar)
+}
+
+// WithSubsystemLevel logs the records at or above the level of NamedLevelVar(name), instead of the level of the
+// handler, so that the level of each subsystem can be adjusted independently at runtime.
+func WithSubsystemLevel(name string) Option {
+	return func(a *adapter) {
+		a.subsystem = NamedLevelVar(name)
+	}
+}
+
+// WithSeverityRemap replaces the level of every record with the one returned by fn, before checking whether the
+// record is logged, e.g. to log the warnings of a library treating warn as its highest level as errors.
+func WithSeverityRemap(fn func(slog.Level) slog.Level) Option {
+	return func(a *adapter) {
+		a.remap = fn
+	}
+}
+
+// enabled reports whether a record at the given level is logged.
+func (a *adapter) enabled(ctx context.Context, level slog.Level) bool {
+	if a.verboseLevel != nil && level >= *a.verboseLevel && IsVerbose(ctx) {
+		return true
+	}
+
+	if a.subsystem != nil {
+		return level >= a.subsystem.Level()
+	}
+
+	return a.logger.Enabled(ctx, level)
+}
+
+// level returns the level of a record logged at the given level.
+func (a *adapter) level(level slog.Level) slog.Level {
+	if a.remap != nil {
+		return a.remap(level)
+	}
+
+	return level
+}
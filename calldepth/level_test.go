@@ -0,0 +1,105 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestWithContextVerbose(t *testing.T) {
+	a, buf := newJSONAt(slog.LevelInfo, calldepth.WithContextVerbose(slog.LevelDebug))
+
+	a.DebugContext(context.Background(), "quiet")
+	a.DebugContext(calldepth.ContextWithVerbose(context.Background(), true), "verbose")
+
+	if record := single(t, buf); record["msg"] != "verbose" || record["level"] != "DEBUG" {
+		t.Errorf("record = %v, want the debug record of the verbose context only", record)
+	}
+}
+
+func TestWithPromoteOnRepeat(t *testing.T) {
+	a, rec := newRecorder(calldepth.WithPromoteOnRepeat(slog.LevelWarn, slog.LevelError, 2, time.Minute))
+
+	for range [3]struct{}{} {
+		a.Warn("disk almost full")
+	}
+
+	a.Warn("other")
+
+	levels := make([]slog.Level, 0, len(rec.records))
+	for _, record := range rec.records {
+		levels = append(levels, record.Level)
+	}
+
+	if want := []slog.Level{slog.LevelWarn, slog.LevelWarn, slog.LevelError, slog.LevelWarn}; !slices.Equal(levels, want) {
+		t.Errorf("levels = %v, want %v", levels, want)
+	}
+}
+
+func TestWithSeverityRemap(t *testing.T) {
+	a, buf := newJSONAt(slog.LevelError, calldepth.WithSeverityRemap(func(level slog.Level) slog.Level {
+		if level >= slog.LevelWarn {
+			return slog.LevelError
+		}
+
+		return level
+	}))
+
+	a.Warn("library failure")
+	a.Info("library info")
+
+	if record := single(t, buf); record["msg"] != "library failure" || record["level"] != "ERROR" {
+		t.Errorf("record = %v, want the warning logged as an error", record)
+	}
+}
+
+func TestWithSubsystemLevel(t *testing.T) {
+	db, http := calldepth.NamedLevelVar("test.db"), calldepth.NamedLevelVar("test.http")
+	t.Cleanup(func() {
+		db.Set(slog.LevelInfo)
+		http.Set(slog.LevelInfo)
+	})
+
+	if calldepth.NamedLevelVar("test.db") != db {
+		t.Fatal("NamedLevelVar returned another level for the same name")
+	}
+
+	dbLogger, dbBuf := newJSON(calldepth.WithSubsystemLevel("test.db"))
+	httpLogger, httpBuf := newJSON(calldepth.WithSubsystemLevel("test.http"))
+
+	dbLogger.Debug("query")
+	db.Set(slog.LevelDebug)
+	dbLogger.Debug("query")
+	httpLogger.Debug("request")
+	httpLogger.Info("request")
+
+	if got := messages(records(t, dbBuf)); !slices.Equal(got, []string{"query"}) {
+		t.Errorf("db messages = %v, want the debug record logged once its level was lowered", got)
+	}
+
+	if got := messages(records(t, httpBuf)); !slices.Equal(got, []string{"request"}) {
+		t.Errorf("http messages = %v, want its level left at info", got)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// OperationKey is the key of the attribute naming the operation logged by Begin.
+	OperationKey = "op"
+
+	// OperationIDKey is the key of the attribute holding the id generated for an operation by Operation.
+	OperationIDKey = "op_id"
+
+	// DurationKey is the key of the attribute holding the duration of an operation.
+	DurationKey = "duration"
+
+	// RequestIDKey is the key of the attribute holding the request id logged by BeginRequest and EndRequest.
+	RequestIDKey = "request_id"
+
+	// BeginRequestMessage is the message logged by BeginRequest.
+	BeginRequestMessage = "----- begin request -----"
+
+	// EndRequestMessage is the message logged by EndRequest.
+	EndRequestMessage = "----- end request -----"
+)
+
+// Begin logs the start of the operation at debug level and returns a function logging its completion with its
+// duration, at info level or, if err is not nil, at error level. It pairs with defer, e.g.
+//
+//	done := calldepth.Begin(ctx, a, "sync")
+//	defer func() { done(err) }()
+func Begin(ctx context.Context, a Adapter, op string, args ...any) func(err error) {
+	start := time.Now()
+	logger := adapterOf(a).with(append([]any{slog.String(OperationKey, op)}, args...)...)
+
+	logger.logattrs(ctx, slog.LevelDebug, op+" started")
+
+	return func(err error) {
+		if err != nil {
+			logger.logattrs(ctx, slog.LevelError, op+" failed", append(errorAttrs(err), slog.Duration(DurationKey, time.Since(start)))...)
+
+			return
+		}
+
+		logger.logattrs(ctx, slog.LevelInfo, op+" completed", slog.Duration(DurationKey, time.Since(start)))
+	}
+}
+
+// Operation derives an adapter for the unit of work with the given name, with the name and a generated id as
+// attributes, and returns a copy of ctx carrying it for FromContext, together with a function logging the completion
+// of the operation with its duration, at info level or, if err is not nil, at error level, e.g.
+//
+//	ctx, done := calldepth.Operation(ctx, a, "import")
+//	defer func() { done(err) }()
+func Operation(ctx context.Context, a Adapter, name string) (context.Context, func(err error)) {
+	impl := adapterOf(a)
+	if ctx == nil {
+		ctx = impl.background()
+	}
+
+	start := time.Now()
+	logger := impl.with(slog.String(OperationKey, name), slog.String(OperationIDKey, newUUID()))
+	ctx = IntoContext(ctx, logger)
+
+	return ctx, func(err error) {
+		if err != nil {
+			logger.logattrs(ctx, slog.LevelError, name+" failed", append(errorAttrs(err), slog.Duration(DurationKey, time.Since(start)))...)
+
+			return
+		}
+
+		logger.logattrs(ctx, slog.LevelInfo, name+" completed", slog.Duration(DurationKey, time.Since(start)))
+	}
+}
+
+// DeferLevel captures a record with the message and the arguments, its time and its source, and returns a function
+// logging it at the given level, for operations whose importance is only known once they are done, e.g. to log a
+// request at warn level only if it failed. The record is logged at most once, by the first call to the function.
+func DeferLevel(ctx context.Context, logger Adapter, msg string, args ...any) func(level slog.Level) {
+	a := adapterOf(logger)
+	if a.strictAttrs {
+		a.report(ErrStrictAttrs)
+
+		return func(slog.Level) {}
+	}
+
+	if ctx == nil {
+		ctx = a.background()
+	}
+
+	record := a.capture(ctx, msg)
+
+	var once sync.Once
+
+	return func(level slog.Level) {
+		once.Do(func() {
+			level = a.level(level)
+
+			if !a.enabled(ctx, level) {
+				return
+			}
+
+			sampled := a.sampled(ctx, level)
+			if !sampled && a.dropped == nil {
+				return
+			}
+
+			if !a.sourceEnabled(level) {
+				record.PC = 0
+			}
+
+			record.Level = level
+			record.Add(argsForLevel(level, args)...)
+
+			a.handle(ctx, record, sampled)
+		})
+	}
+}
+
+// capture returns a record with the message, the time and the source of the caller of DeferLevel, leaving the level to
+// be set when it is logged. It must be called directly by DeferLevel.
+func (a *adapter) capture(ctx context.Context, msg string) slog.Record {
+	return slog.NewRecord(time.Now(), slog.LevelInfo, msg, a.caller(ctx))
+}
+
+// BeginRequest logs a marker at info level for the start of the request with the given id, making it easy to bracket
+// the logs of a request in text output.
+func BeginRequest(a Adapter, id string) {
+	adapterOf(a).logattrs(context.Background(), slog.LevelInfo, BeginRequestMessage, slog.String(RequestIDKey, id))
+}
+
+// EndRequest logs a marker at info level for the end of the request with the given id.
+func EndRequest(a Adapter, id string) {
+	adapterOf(a).logattrs(context.Background(), slog.LevelInfo, EndRequestMessage, slog.String(RequestIDKey, id))
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"slices"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestBegin(t *testing.T) {
+	a, buf := newJSON()
+
+	_, _, line, _ := runtime.Caller(0)
+	done := calldepth.Begin(context.Background(), a, "sync", "user", "alice")
+	done(nil)
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	started, completed := out[0], out[1]
+	if started["level"] != "DEBUG" || started["msg"] != "sync started" || started[calldepth.OperationKey] != "sync" || started["user"] != "alice" {
+		t.Errorf("start = %v, want a debug record with the arguments", started)
+	}
+
+	if got := sourceLine(t, started); got != line+1 {
+		t.Errorf("start source line = %d, want %d", got, line+1)
+	}
+
+	if completed["level"] != "INFO" || completed["msg"] != "sync completed" || completed[calldepth.DurationKey] == nil {
+		t.Errorf("completion = %v, want an info record with the duration", completed)
+	}
+
+	if got := sourceLine(t, completed); got != line+2 {
+		t.Errorf("completion source line = %d, want %d", got, line+2)
+	}
+}
+
+func TestBeginFailed(t *testing.T) {
+	a, buf := newJSON()
+
+	calldepth.Begin(context.Background(), a, "sync")(errors.New("unavailable"))
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	if failed := out[1]; failed["level"] != "ERROR" || failed["msg"] != "sync failed" || failed[calldepth.ErrorKey] != "unavailable" {
+		t.Errorf("completion = %v, want an error record with the error", failed)
+	}
+}
+
+func TestBeginStrictAttrs(t *testing.T) {
+	var errs []error
+
+	a, buf := newJSON(calldepth.WithStrictAttrs(), calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	calldepth.Begin(context.Background(), a, "sync")(nil)
+	calldepth.Begin(context.Background(), a, "sync")(errors.New("unavailable"))
+
+	_, done := calldepth.Operation(context.Background(), a, "import")
+	done(nil)
+
+	want := []string{"sync started", "sync completed", "sync started", "sync failed", "import completed"}
+	if got := messages(records(t, buf)); !slices.Equal(got, want) {
+		t.Errorf("messages = %v, want %v: the records are built from attributes", got, want)
+	}
+
+	if len(errs) != 0 {
+		t.Errorf("errors = %v, want none", errs)
+	}
+}
+
+func TestBeginRequest(t *testing.T) {
+	a, buf := newJSON()
+
+	calldepth.BeginRequest(a, "req-1")
+	calldepth.EndRequest(a, "req-1")
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	for i, msg := range []string{calldepth.BeginRequestMessage, calldepth.EndRequestMessage} {
+		if out[i]["msg"] != msg || out[i][calldepth.RequestIDKey] != "req-1" {
+			t.Errorf("record = %v, want the marker %q with the request id", out[i], msg)
+		}
+	}
+}
+
+func TestOperation(t *testing.T) {
+	a, buf := newJSON()
+
+	ctx, done := calldepth.Operation(context.Background(), a, "import")
+	calldepth.FromContext(ctx).InfoContext(ctx, "step")
+	done(nil)
+
+	_, failed := calldepth.Operation(context.Background(), a, "import")
+	failed(errors.New("unavailable"))
+
+	out := records(t, buf)
+	if len(out) != 3 {
+		t.Fatalf("got %d records, want 3", len(out))
+	}
+
+	step, completed := out[0], out[1]
+	if step[calldepth.OperationKey] != "import" || step[calldepth.OperationIDKey] == nil {
+		t.Errorf("step = %v, want the operation and its id", step)
+	}
+
+	if completed["msg"] != "import completed" || completed[calldepth.DurationKey] == nil || completed[calldepth.OperationIDKey] != step[calldepth.OperationIDKey] {
+		t.Errorf("completion = %v, want the duration and the id of the operation", completed)
+	}
+
+	if out[2]["level"] != "ERROR" || out[2]["msg"] != "import failed" || out[2][calldepth.ErrorKey] != "unavailable" {
+		t.Errorf("failure = %v, want an error record with the error", out[2])
+	}
+
+	if out[2][calldepth.OperationIDKey] == step[calldepth.OperationIDKey] {
+		t.Errorf("operations share the id %v, want one per operation", step[calldepth.OperationIDKey])
+	}
+}
+
+func TestDeferLevel(t *testing.T) {
+	a, buf := newJSON()
+
+	_, _, line, _ := runtime.Caller(0)
+	done := calldepth.DeferLevel(context.Background(), a, "request", "path", "/users")
+
+	if buf.Len() != 0 {
+		t.Fatalf("record logged before its level was decided: %s", buf)
+	}
+
+	done(slog.LevelWarn)
+	done(slog.LevelError)
+
+	record := single(t, buf)
+	if record["level"] != "WARN" || record["msg"] != "request" || record["path"] != "/users" {
+		t.Errorf("record = %v, want the record at the level of the first call", record)
+	}
+
+	if got := sourceLine(t, record); got != line+1 {
+		t.Errorf("source line = %d, want %d, the call to DeferLevel", got, line+1)
+	}
+}
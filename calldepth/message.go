@@ -0,0 +1,183 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const (
+	// EventKey is the key of the attribute holding the name of the event logged by Event.
+	EventKey = "event"
+
+	// MessageTemplateKey is the key of the attribute holding the template of the message added by
+	// WithTemplateExtraction.
+	MessageTemplateKey = "message_template"
+)
+
+var (
+	// ErrTemplateKey is reported by WithStrictTemplate when a placeholder of the message has no matching attribute.
+	ErrTemplateKey = errors.New("calldepth: no attribute for template placeholder")
+
+	// uuidPattern matches the UUIDs replaced by NormalizeMessage.
+	uuidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+	// numberPattern matches the numbers replaced by NormalizeMessage.
+	numberPattern = regexp.MustCompile(`\d+(?:\.\d+)?`)
+)
+
+// Event logs a record at info level with the message for humans and the stable name of the event, e.g.
+// "user.signup", as the `event` attribute for machines, so that downstream systems key on the name while the message
+// is free to change. With WithStrictAttrs, the record is logged with the arguments that are a slog.Attr only.
+func Event(ctx context.Context, a Adapter, name, msg string, args ...any) {
+	impl := adapterOf(a)
+	impl.logattrs(ctx, slog.LevelInfo, msg, append([]slog.Attr{slog.String(EventKey, name)}, impl.argsAttrs(args)...)...)
+}
+
+// WithEventMode replaces empty messages with defaultMsg, e.g. "event", for event style logging where the attributes
+// carry the meaning, so that downstream systems requiring a message accept the record.
+func WithEventMode(defaultMsg string) Option {
+	return func(a *adapter) {
+		a.message = append(a.message, func(msg string) string {
+			if msg == "" {
+				return defaultMsg
+			}
+
+			return msg
+		})
+	}
+}
+
+// WithTemplate replaces the `{key}` placeholders of messages with the values of the attributes of the record with the
+// same key, e.g. "user {user} signed in". Only the attributes given to the logging call and the extracted ones are
+// looked up, not those added with With. Placeholders without a matching attribute are left as is.
+func WithTemplate() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			interpolate(record)
+		})
+	}
+}
+
+// WithStrictTemplate is WithTemplate, also reporting ErrTemplateKey to the error handler for every placeholder
+// without a matching attribute, to catch typos in templated messages.
+func WithStrictTemplate() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			template := record.Message
+
+			for _, key := range interpolate(record) {
+				a.report(fmt.Errorf("%w: %q in %q", ErrTemplateKey, key, template))
+			}
+		})
+	}
+}
+
+// WithTemplateExtraction adds the message of every record normalized with NormalizeMessage as `message_template`, so
+// that records such as "user 123 failed" and "user 456 failed" can be aggregated. Set before WithTemplate, the template
+// is extracted from the message as written rather than interpolated.
+func WithTemplateExtraction() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			record.AddAttrs(slog.String(MessageTemplateKey, NormalizeMessage(record.Message)))
+		})
+	}
+}
+
+// NormalizeMessage returns the message with its variable parts replaced by placeholders: UUIDs by `<uuid>`, then
+// numbers by `<num>`.
+func NormalizeMessage(msg string) string {
+	msg = uuidPattern.ReplaceAllLiteralString(msg, "<uuid>")
+
+	return numberPattern.ReplaceAllLiteralString(msg, "<num>")
+}
+
+// interpolate replaces the placeholders of the message of the record with the values of its attributes, and returns
+// the keys of the placeholders without a matching attribute.
+func interpolate(record *slog.Record) []string {
+	msg := record.Message
+	if !strings.Contains(msg, "{") {
+		return nil
+	}
+
+	var (
+		b       strings.Builder
+		values  map[string]string
+		missing []string
+	)
+
+	for {
+		start := strings.IndexByte(msg, '{')
+		if start < 0 {
+			break
+		}
+
+		end := strings.IndexByte(msg[start:], '}')
+		if end < 0 {
+			break
+		}
+
+		end += start
+		key := msg[start+1 : end]
+
+		if values == nil {
+			values = make(map[string]string, record.NumAttrs())
+
+			record.Attrs(func(attr slog.Attr) bool {
+				values[attr.Key] = attr.Value.String()
+
+				return true
+			})
+		}
+
+		b.WriteString(msg[:start])
+
+		if value, ok := values[key]; ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(msg[start : end+1])
+
+			if key != "" {
+				missing = append(missing, key)
+			}
+		}
+
+		msg = msg[end+1:]
+	}
+
+	b.WriteString(msg)
+
+	interpolated := slog.NewRecord(record.Time, record.Level, b.String(), record.PC)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		interpolated.AddAttrs(attr)
+
+		return true
+	})
+
+	*record = interpolated
+
+	return missing
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestWithEventMode(t *testing.T) {
+	a, buf := newJSON(calldepth.WithEventMode("event"))
+
+	a.Info("", "user", "alice")
+	a.Info("signed in")
+
+	out := records(t, buf)
+	if len(out) != 2 || out[0]["msg"] != "event" || out[1]["msg"] != "signed in" {
+		t.Errorf("records = %v, want the empty message replaced only", out)
+	}
+}
+
+func TestWithTemplate(t *testing.T) {
+	a, buf := newJSON(calldepth.WithTemplate())
+
+	a.Info("user {user} signed in from {ip}", "user", "alice")
+
+	if record := single(t, buf); record["msg"] != "user alice signed in from {ip}" || record["user"] != "alice" {
+		t.Errorf("record = %v, want the placeholder with an attribute replaced", record)
+	}
+}
+
+func TestWithStrictTemplate(t *testing.T) {
+	var errs []error
+
+	a, buf := newJSON(calldepth.WithStrictTemplate(), calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	a.Info("user {usr} signed in", "user", "alice")
+
+	if record := single(t, buf); record["msg"] != "user {usr} signed in" {
+		t.Errorf("msg = %v, want the placeholder left as is", record["msg"])
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], calldepth.ErrTemplateKey) {
+		t.Errorf("errors = %v, want ErrTemplateKey", errs)
+	}
+}
+
+func TestEvent(t *testing.T) {
+	a, buf := newJSON()
+
+	_, _, line, _ := runtime.Caller(0)
+	calldepth.Event(context.Background(), a, "user.signup", "user signed up", "user", "alice")
+
+	record := single(t, buf)
+	if record["level"] != "INFO" || record["msg"] != "user signed up" || record[calldepth.EventKey] != "user.signup" || record["user"] != "alice" {
+		t.Errorf("record = %v, want an info record with the event name and the arguments", record)
+	}
+
+	if got := sourceLine(t, record); got != line+1 {
+		t.Errorf("source line = %d, want %d", got, line+1)
+	}
+}
+
+func TestNormalizeMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"user 123 failed", "user <num> failed"},
+		{"took 1.5s for 3 items", "took <num>s for <num> items"},
+		{"job 6ba7b810-9dad-11d1-80b4-00c04fd430c8 done", "job <uuid> done"},
+		{"no variable parts", "no variable parts"},
+	}
+
+	for _, tt := range tests {
+		if got := calldepth.NormalizeMessage(tt.msg); got != tt.want {
+			t.Errorf("NormalizeMessage(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestWithTemplateExtraction(t *testing.T) {
+	a, buf := newJSON(calldepth.WithTemplateExtraction())
+
+	a.Info("user 123 failed")
+	a.Info("user 456 failed")
+
+	for _, record := range records(t, buf) {
+		if record[calldepth.MessageTemplateKey] != "user <num> failed" {
+			t.Errorf("record = %v, want the template of the message", record)
+		}
+	}
+}
+
+func TestEventStrictAttrs(t *testing.T) {
+	var errs []error
+
+	a, buf := newJSON(calldepth.WithStrictAttrs(), calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	calldepth.Event(context.Background(), a, "user.signup", "user signed up", slog.String("user", "alice"))
+
+	record := single(t, buf)
+	if record[calldepth.EventKey] != "user.signup" || record["user"] != "alice" {
+		t.Errorf("record = %v, want the event logged with its attributes", record)
+	}
+
+	if len(errs) != 0 {
+		t.Errorf("errors = %v, want none", errs)
+	}
+}
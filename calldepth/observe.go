@@ -0,0 +1,182 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// SeverityTracker tracks the highest level of the records logged since it was last reset. It is safe for
+	// concurrent use.
+	SeverityTracker struct {
+		max atomic.Int64
+	}
+
+	// emitObserver is called by WithEmitObserver with the records passed to the handler.
+	emitObserver func(ctx context.Context, record slog.Record)
+)
+
+const (
+	// NoSeverity is the level returned by SeverityTracker.Max when no record was logged since the last reset. It is
+	// lower than any level.
+	NoSeverity slog.Level = math.MinInt32
+)
+
+// WithSizeObserver calls fn with the estimated size in bytes of every record logged, e.g. to track the logging
+// bandwidth. The estimate is the length of the message plus the lengths of the attribute keys and values, without
+// the formatting overhead of the handler nor the attributes added with With.
+func WithSizeObserver(fn func(bytes int)) Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			fn(recordSize(*record))
+		})
+	}
+}
+
+// WithEmitObserver calls fn with every record passed to the handler, once the hooks ran and the filters kept it, e.g.
+// to count the records emitted. Unlike a hook, fn does not see the records dropped by filters such as WithDebounce
+// or WithMaxRecordBytes. It must not keep the record past the call.
+func WithEmitObserver(fn func(ctx context.Context, record slog.Record)) Option {
+	return func(a *adapter) {
+		a.emitted = append(a.emitted, fn)
+	}
+}
+
+// WithMaxRecordBytes drops the records whose size estimated as for WithSizeObserver exceeds n bytes, calling
+// onOversize with each of them first, e.g. to count them, rather than letting a transport with a hard limit on the
+// size of messages reject them. Dropped records go to the dropped sink, if any.
+func WithMaxRecordBytes(n int, onOversize func(slog.Record)) Option {
+	return func(a *adapter) {
+		a.filters = append(a.filters, func(_ context.Context, record *slog.Record) bool {
+			if recordSize(*record) <= n {
+				return true
+			}
+
+			if onOversize != nil {
+				onOversize(*record)
+			}
+
+			return false
+		})
+	}
+}
+
+// WithMessageLengthObserver calls fn with the length in bytes of the message of every record logged, e.g. to feed a
+// histogram catching the call sites logging huge messages.
+func WithMessageLengthObserver(fn func(n int)) Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			fn(len(record.Message))
+		})
+	}
+}
+
+// WithAttrCountObserver calls fn with the number of attributes of every record logged, groups counting as one, e.g. to
+// alert on call sites whose cardinality grows unexpectedly. The attributes added with With are not counted.
+func WithAttrCountObserver(fn func(n int)) Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			fn(record.NumAttrs())
+		})
+	}
+}
+
+// WithMaxSeverityTracking returns an option tracking the highest level logged, and the tracker to read it from, e.g.
+// for a readiness probe failing if errors were logged recently.
+func WithMaxSeverityTracking() (Option, *SeverityTracker) {
+	tracker := &SeverityTracker{}
+	tracker.Reset()
+
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			tracker.observe(record.Level)
+		})
+	}, tracker
+}
+
+// Max returns the highest level logged since the last reset, or NoSeverity if none was.
+func (t *SeverityTracker) Max() slog.Level {
+	return slog.Level(t.max.Load())
+}
+
+// Reset forgets the levels logged so far.
+func (t *SeverityTracker) Reset() {
+	t.max.Store(int64(NoSeverity))
+}
+
+// observe raises the highest level to the given level, if lower.
+func (t *SeverityTracker) observe(level slog.Level) {
+	for {
+		current := t.max.Load()
+		if int64(level) <= current || t.max.CompareAndSwap(current, int64(level)) {
+			return
+		}
+	}
+}
+
+// recordSize estimates the size in bytes of the record.
+func recordSize(record slog.Record) int {
+	size := len(record.Message)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		size += attrSize(attr)
+
+		return true
+	})
+
+	return size
+}
+
+// attrSize estimates the size in bytes of the attribute.
+func attrSize(attr slog.Attr) int {
+	var buf [32]byte
+
+	size := len(attr.Key)
+	value := attr.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindString:
+		size += len(value.String())
+	case slog.KindInt64:
+		size += len(strconv.AppendInt(buf[:0], value.Int64(), 10))
+	case slog.KindUint64:
+		size += len(strconv.AppendUint(buf[:0], value.Uint64(), 10))
+	case slog.KindFloat64:
+		size += len(strconv.AppendFloat(buf[:0], value.Float64(), 'g', -1, 64))
+	case slog.KindBool:
+		size += len(strconv.AppendBool(buf[:0], value.Bool()))
+	case slog.KindTime:
+		size += len(time.RFC3339Nano)
+	case slog.KindGroup:
+		for _, member := range value.Group() {
+			size += attrSize(member)
+		}
+	case slog.KindDuration, slog.KindAny, slog.KindLogValuer:
+		size += len(value.String())
+	}
+
+	return size
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestWithSizeObserver(t *testing.T) {
+	var sizes []int
+
+	a, _ := newJSON(calldepth.WithSizeObserver(func(n int) { sizes = append(sizes, n) }))
+
+	a.Info("hello", "user", "alice", "n", 123, slog.Group("db", "q", "x"))
+	a.Info("hello", "user", "alice, bob and carol")
+
+	// the lengths of the message, of the keys and of the values.
+	if want := []int{5 + 4 + 5 + 1 + 3 + 2 + 1 + 1, 5 + 4 + 20}; !slices.Equal(sizes, want) {
+		t.Errorf("sizes = %v, want %v", sizes, want)
+	}
+}
+
+func TestWithMaxSeverityTracking(t *testing.T) {
+	tracking, tracker := calldepth.WithMaxSeverityTracking()
+	a, _ := newJSON(tracking)
+
+	if got := tracker.Max(); got != calldepth.NoSeverity {
+		t.Errorf("max = %v before any record, want NoSeverity", got)
+	}
+
+	a.Error("failed")
+	a.Info("ok")
+
+	if got := tracker.Max(); got != slog.LevelError {
+		t.Errorf("max = %v, want ERROR", got)
+	}
+
+	tracker.Reset()
+	a.Warn("slow")
+
+	if got := tracker.Max(); got != slog.LevelWarn {
+		t.Errorf("max = %v after a reset, want WARN", got)
+	}
+}
+
+func TestWithMessageLengthObserver(t *testing.T) {
+	var lengths []int
+
+	a, _ := newJSONAt(slog.LevelInfo, calldepth.WithMessageLengthObserver(func(n int) { lengths = append(lengths, n) }))
+
+	a.Info("hello")
+	a.Debug("disabled")
+	a.Info("héllo", "user", "alice")
+
+	// the length in bytes, é taking two.
+	if want := []int{5, 6}; !slices.Equal(lengths, want) {
+		t.Errorf("lengths = %v, want %v", lengths, want)
+	}
+}
+
+func TestWithMaxRecordBytes(t *testing.T) {
+	var oversize []string
+
+	dropped := &recorder{}
+	a, buf := newJSON(
+		calldepth.WithMaxRecordBytes(10, func(r slog.Record) { oversize = append(oversize, r.Message) }),
+		calldepth.WithDroppedSink(dropped),
+	)
+
+	a.Info("hello")
+	a.Info("big", "user", "alice") // 3 + 4 + 5 bytes.
+
+	if got := messages(records(t, buf)); !slices.Equal(got, []string{"hello"}) {
+		t.Errorf("messages = %v, want the small record only", got)
+	}
+
+	if !slices.Equal(oversize, []string{"big"}) {
+		t.Errorf("oversize = %v, want the big record", oversize)
+	}
+
+	if len(dropped.records) != 1 || dropped.records[0].Message != "big" {
+		t.Errorf("dropped = %v, want the big record in the dropped sink", dropped.records)
+	}
+}
+
+func TestWithAttrCountObserver(t *testing.T) {
+	var counts []int
+
+	a, _ := newJSON(calldepth.WithAttrCountObserver(func(n int) { counts = append(counts, n) }))
+
+	a.Info("m")
+	a.With("request", "r1").Info("m", "user", "alice", slog.Group("db", "table", "users", "id", 42))
+
+	// groups count as one, the attributes added with With are not counted.
+	if want := []int{0, 2}; !slices.Equal(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+}
+
+func TestWithEmitObserver(t *testing.T) {
+	var emitted []string
+
+	a, buf := newJSON(
+		calldepth.WithEmitObserver(func(_ context.Context, r slog.Record) { emitted = append(emitted, r.Message) }),
+		calldepth.WithMaxRecordBytes(5, nil),
+	)
+
+	a.Info("small")
+	a.Info("too large")
+
+	if got := messages(records(t, buf)); !slices.Equal(emitted, got) {
+		t.Errorf("emitted = %v, want the records passed to the handler %v", emitted, got)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+)
+
+const (
+	// PanicMessage is the message of the records logged by RecoverAndLog.
+	PanicMessage = "panic recovered"
+)
+
+// WithPanicReplay keeps the latest n records that were not logged because of their level, e.g. debug records, and
+// logs them ahead of the panic logged by RecoverAndLog, to show what led up to it. The records are logged with the
+// attributes of the call and of With, without those of the context extractors and the hooks.
+//
+// Every record below the level of the handler is built with this option, which adds to the cost of disabled calls.
+func WithPanicReplay(n int) Option {
+	return func(a *adapter) {
+		a.panicRing = newRing(n)
+	}
+}
+
+// RecoverAndLog recovers from a panic and logs it at error level, with the stack trace, as the source of the record
+// the function that panicked. It must be deferred directly, e.g.
+//
+//	defer calldepth.RecoverAndLog(ctx, a)
+//
+// With WithPanicReplay, the records kept are logged first.
+func RecoverAndLog(ctx context.Context, logger Adapter) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	a := adapterOf(logger)
+
+	if a.panicRing != nil {
+		a.report(a.panicRing.flush())
+	}
+
+	err, ok := recovered.(error)
+	if !ok {
+		err = fmt.Errorf("panic: %v", recovered)
+	}
+
+	// skip the frame of the runtime raising the panic.
+	c := a.clone(a.logger)
+	c.depth++
+
+	c.logattrs(ctx, slog.LevelError, PanicMessage, slog.Any(ErrorKey, err), slog.String(StackTraceKey, string(debug.Stack())))
+}
+
+// remember keeps the record that was not logged for WithPanicReplay.
+func (a *adapter) remember(ctx context.Context, record slog.Record) {
+	a.panicRing.push(buffered{ctx: ctx, handler: a.logger.Handler(), record: record})
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestRecoverAndLog(t *testing.T) {
+	a, buf := newJSON()
+
+	var line int
+
+	func() {
+		defer calldepth.RecoverAndLog(context.Background(), a)
+
+		_, _, line, _ = runtime.Caller(0)
+		panic("boom")
+	}()
+
+	record := single(t, buf)
+	if record["level"] != "ERROR" || record["msg"] != calldepth.PanicMessage || record[calldepth.ErrorKey] != "panic: boom" {
+		t.Errorf("record = %v, want an error record with the panic", record)
+	}
+
+	if stack, _ := record[calldepth.StackTraceKey].(string); !strings.Contains(stack, "TestRecoverAndLog") {
+		t.Errorf("stack trace = %q, want the frames of the panicking function", stack)
+	}
+
+	if got := sourceLine(t, record); got != line+1 {
+		t.Errorf("source line = %d, want %d, the call to panic", got, line+1)
+	}
+}
+
+func TestWithPanicReplay(t *testing.T) {
+	a, buf := newJSONAt(slog.LevelInfo, calldepth.WithPanicReplay(2))
+
+	func() {
+		defer calldepth.RecoverAndLog(context.Background(), a)
+
+		a.Debug("connecting")
+		a.Debug("retrying")
+		a.Info("logged")
+		a.Debug("giving up")
+		panic("boom")
+	}()
+
+	if got, want := messages(records(t, buf)), []string{"logged", "retrying", "giving up", calldepth.PanicMessage}; !slices.Equal(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+}
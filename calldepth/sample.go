@@ -0,0 +1,337 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"log/slog"
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// Sampler reports whether a record at the given level should be logged. It is called before the record is built,
+	// so dropped records cost neither the caller lookup nor the attributes.
+	Sampler func(ctx context.Context, level slog.Level) bool
+
+	// sampleKey is the context key for the sample decision.
+	sampleKey struct{}
+
+	// debouncer tracks when each key was last logged, for WithDebounce.
+	debouncer struct {
+		mu       sync.Mutex
+		interval time.Duration
+		keys     map[string]*list.Element // keys are the elements of order, by key.
+		order    *list.List               // order holds the *debounced of the keys, the key logged last in front.
+	}
+
+	// samplingStats counts the records kept and dropped by the sampler per level, over a window.
+	samplingStats struct {
+		mu       sync.Mutex
+		interval time.Duration
+		start    time.Time
+		kept     map[slog.Level]int
+		dropped  map[slog.Level]int
+	}
+
+	// LoadThreshold is the ratio of the records kept by the sampler of WithLoadBasedSampler once the load reaches Load.
+	LoadThreshold struct {
+		Load  float64 // Load is the lowest load the ratio applies to.
+		Ratio float64 // Ratio is the ratio, between 0 and 1, of the records kept.
+	}
+
+	// debounced is the last time a key was logged, and the number of its records dropped since.
+	debounced struct {
+		key        string
+		last       time.Time
+		suppressed int
+	}
+)
+
+const (
+	// SamplingReportMessage is the message of the records logged by WithSamplingReport.
+	SamplingReportMessage = "sampling report"
+
+	// SuppressedKey is the key of the attribute holding the number of records dropped by WithDebounce since the
+	// previous record with the same key.
+	SuppressedKey = "suppressed"
+)
+
+// WithSampler sets the sampler deciding which records are logged.
+func WithSampler(fn Sampler) Option {
+	return func(a *adapter) {
+		a.sampler = fn
+	}
+}
+
+// WithContextSampling honors the decision stored with ContextWithSampleDecision over that of the sampler, e.g. to
+// keep all the records of a request carrying a debug header.
+func WithContextSampling() Option {
+	return func(a *adapter) {
+		a.ctxSampling = true
+	}
+}
+
+// WithDroppedSink passes the records dropped by the sampler to h, e.g. a low volume handler used to verify the
+// sampling configuration. Dropped records are built only when a sink is set, and get the same rewrites and attributes
+// as the records kept.
+func WithDroppedSink(h slog.Handler) Option {
+	return func(a *adapter) {
+		a.dropped = slog.New(h)
+	}
+}
+
+// WithDebounce logs at most one record per interval for each key returned by keyFn, e.g. the message, dropping the
+// others. The next record logged for the key carries the number of records dropped in between as `suppressed`.
+// Distinct keys do not affect each other.
+func WithDebounce(d time.Duration, keyFn func(r slog.Record) string) Option {
+	return func(a *adapter) {
+		db := &debouncer{interval: d, keys: make(map[string]*list.Element), order: list.New()}
+
+		a.filters = append(a.filters, func(_ context.Context, record *slog.Record) bool {
+			keep, suppressed := db.allow(keyFn(*record), record.Time, a.dedupCacheSize())
+			if keep && suppressed > 0 {
+				record.AddAttrs(slog.Int(SuppressedKey, suppressed))
+			}
+
+			return keep
+		})
+	}
+}
+
+// allow reports whether a record with the key can be logged at the given time, and the number of records with the
+// key dropped since the last one logged. At most size keys are tracked.
+func (db *debouncer) allow(key string, now time.Time, size int) (bool, int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	elem, ok := db.keys[key]
+	if !ok {
+		db.evict(now, size)
+		db.keys[key] = db.order.PushFront(&debounced{key: key, last: now})
+
+		return true, 0
+	}
+
+	entry, _ := elem.Value.(*debounced)
+	if now.Sub(entry.last) < db.interval {
+		entry.suppressed++
+
+		return false, 0
+	}
+
+	suppressed := entry.suppressed
+	entry.last, entry.suppressed = now, 0
+	db.order.MoveToFront(elem)
+
+	return true, suppressed
+}
+
+// evict removes the keys logged the longest ago while their interval expired or there is no room for a new key, so
+// that the recent keys keep being debounced while memory stays bounded. Each key is removed in constant time.
+func (db *debouncer) evict(now time.Time, size int) {
+	for back := db.order.Back(); back != nil; back = db.order.Back() {
+		entry, _ := back.Value.(*debounced)
+		if db.order.Len() < size && now.Sub(entry.last) < db.interval {
+			return
+		}
+
+		db.order.Remove(back)
+		delete(db.keys, entry.key)
+	}
+}
+
+// WithDedupCacheSize sets the number of distinct keys tracked by WithDebounce, 1024 by default. Once n keys are
+// tracked, a new key evicts the key logged the longest ago, which may then be logged again within its interval. The
+// keys whose interval expired are evicted along the way.
+func WithDedupCacheSize(n int) Option {
+	return func(a *adapter) {
+		a.dedupSize = n
+	}
+}
+
+// dedupCacheSize returns the number of keys tracked by WithDebounce.
+func (a *adapter) dedupCacheSize() int {
+	if a.dedupSize > 0 {
+		return a.dedupSize
+	}
+
+	return maxTrackedKeys
+}
+
+// WithSamplingReport logs, at info level, the number of records kept and dropped by the sampler per level once every
+// interval, to show the impact of sampling. The report is logged with the first record sampled after the interval
+// elapsed, so no report is logged while nothing is.
+func WithSamplingReport(interval time.Duration) Option {
+	return func(a *adapter) {
+		a.stats = &samplingStats{
+			interval: interval,
+			start:    time.Now(),
+			kept:     make(map[slog.Level]int),
+			dropped:  make(map[slog.Level]int),
+		}
+	}
+}
+
+// SampleRatio returns a sampler keeping the given ratio, between 0 and 1, of the records at random.
+func SampleRatio(ratio float64) Sampler {
+	return func(context.Context, slog.Level) bool {
+		return rand.Float64() < ratio //nolint:gosec // sampling does not need a secure source.
+	}
+}
+
+// WithLoadBasedSampler sets a sampler keeping fewer records as the load returned by measure, e.g. the depth of a queue
+// or the CPU usage, grows, to keep logging bounded under pressure. See LoadSampler.
+func WithLoadBasedSampler(measure func() float64, thresholds ...LoadThreshold) Option {
+	return WithSampler(LoadSampler(measure, thresholds...))
+}
+
+// LoadSampler returns a sampler keeping, at random, the ratio of the records of the highest threshold reached by the
+// load returned by measure, and all of them below the lowest threshold. The load is measured for every record, so
+// measure should be cheap, e.g. an atomic load of a value updated in the background.
+func LoadSampler(measure func() float64, thresholds ...LoadThreshold) Sampler {
+	thresholds = slices.Clone(thresholds)
+	slices.SortFunc(thresholds, func(a, b LoadThreshold) int {
+		return cmp.Compare(a.Load, b.Load)
+	})
+
+	return func(context.Context, slog.Level) bool {
+		load, ratio := measure(), 1.0
+
+		for _, threshold := range thresholds {
+			if load < threshold.Load {
+				break
+			}
+
+			ratio = threshold.Ratio
+		}
+
+		return ratio >= 1 || rand.Float64() < ratio //nolint:gosec // sampling does not need a secure source.
+	}
+}
+
+// WithLevelSampling sets a sampler keeping the ratio of the records given for their level, e.g. 0.01 for debug
+// records, and all the records at the levels not in the map. See LevelSampler.
+func WithLevelSampling(m map[slog.Level]float64) Option {
+	return WithSampler(LevelSampler(m))
+}
+
+// LevelSampler returns a sampler keeping, at random, the ratio of the records given for their level, between 0 and 1,
+// and all the records at the levels not in the map. Like every sampler, it runs before the source is looked up.
+func LevelSampler(m map[slog.Level]float64) Sampler {
+	ratios := maps.Clone(m)
+
+	return func(_ context.Context, level slog.Level) bool {
+		ratio, ok := ratios[level]
+
+		return !ok || ratio >= 1 || rand.Float64() < ratio //nolint:gosec // sampling does not need a secure source.
+	}
+}
+
+// ContextWithSampleDecision returns a copy of the context that forces the records logged with it to be kept or
+// dropped, regardless of the sampler, by adapters created with WithContextSampling.
+func ContextWithSampleDecision(ctx context.Context, keep bool) context.Context {
+	return context.WithValue(ctx, sampleKey{}, keep)
+}
+
+// SampleDecisionFromContext returns the decision stored with ContextWithSampleDecision, if any.
+func SampleDecisionFromContext(ctx context.Context) (keep, ok bool) {
+	keep, ok = ctx.Value(sampleKey{}).(bool)
+
+	return keep, ok
+}
+
+// sampled reports whether a record at the given level should be logged.
+func (a *adapter) sampled(ctx context.Context, level slog.Level) bool {
+	if a.ctxSampling {
+		if keep, ok := SampleDecisionFromContext(ctx); ok {
+			return keep
+		}
+	}
+
+	keep := a.sampler == nil || a.sampler(ctx, level)
+
+	if a.stats != nil {
+		if report, ok := a.stats.count(level, keep); ok {
+			a.report(a.logger.Handler().Handle(ctx, report))
+		}
+	}
+
+	return keep
+}
+
+// count adds the sampled record to the counts, and returns the report of the window if it elapsed.
+func (s *samplingStats) count(level slog.Level, kept bool) (slog.Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if kept {
+		s.kept[level]++
+	} else {
+		s.dropped[level]++
+	}
+
+	now := time.Now()
+	if now.Sub(s.start) < s.interval {
+		return slog.Record{}, false
+	}
+
+	report := slog.NewRecord(now, slog.LevelInfo, SamplingReportMessage, 0)
+	report.AddAttrs(
+		slog.Duration("window", now.Sub(s.start)),
+		slog.Attr{Key: "kept", Value: levelCounts(s.kept)},
+		slog.Attr{Key: "dropped", Value: levelCounts(s.dropped)},
+	)
+
+	s.start = now
+	s.kept = make(map[slog.Level]int)
+	s.dropped = make(map[slog.Level]int)
+
+	return report, true
+}
+
+// levelCounts returns the counts as a group keyed by level name, from the lowest level.
+func levelCounts(counts map[slog.Level]int) slog.Value {
+	levels := make([]slog.Level, 0, len(counts))
+	for level := range counts {
+		levels = append(levels, level)
+	}
+
+	slices.Sort(levels)
+
+	attrs := make([]slog.Attr, len(levels))
+	for i, level := range levels {
+		attrs[i] = slog.Int(level.String(), counts[level])
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// drop passes the record dropped by the sampler to the dropped sink.
+func (a *adapter) drop(ctx context.Context, record slog.Record) {
+	if err := a.dropped.Handler().Handle(ctx, record); err != nil {
+		a.report(err)
+	}
+}
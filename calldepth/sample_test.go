@@ -0,0 +1,227 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+// never is a sampler dropping every record.
+func never(context.Context, slog.Level) bool { return false }
+
+func TestWithDroppedSink(t *testing.T) {
+	var dropped bytes.Buffer
+
+	a, kept := newJSON(
+		calldepth.WithSampler(func(_ context.Context, level slog.Level) bool { return level >= slog.LevelWarn }),
+		calldepth.WithDroppedSink(slog.NewJSONHandler(&dropped, nil)),
+		calldepth.WithAttrs(slog.String("service", "api")),
+		calldepth.WithNamespace("app"),
+	)
+
+	a.With("user", "alice").Info("dropped")
+	a.With("user", "alice").Warn("kept")
+
+	got, want := records(t, &dropped), records(t, kept)
+	if len(got) != 1 || len(want) != 1 {
+		t.Fatalf("got %d dropped and %d kept records, want 1 each", len(got), len(want))
+	}
+
+	for _, key := range []string{"app.service", "app.user"} {
+		if got[0][key] != want[0][key] || got[0][key] == nil {
+			t.Errorf("%s = %v in the dropped record, %v in the kept one", key, got[0][key], want[0][key])
+		}
+	}
+}
+
+func TestWithDroppedSinkNotSet(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSampler(never))
+
+	a.Info("dropped")
+
+	if buf.Len() != 0 {
+		t.Errorf("dropped record logged: %s", buf)
+	}
+}
+
+func TestWithContextSampling(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSampler(never), calldepth.WithContextSampling())
+
+	a.InfoContext(context.Background(), "dropped")
+	a.InfoContext(calldepth.ContextWithSampleDecision(context.Background(), true), "forced")
+
+	if record := single(t, buf); record["msg"] != "forced" {
+		t.Errorf("record = %v, want the record of the forced context only", record)
+	}
+}
+
+func TestWithContextSamplingDrop(t *testing.T) {
+	a, buf := newJSON(calldepth.WithContextSampling())
+
+	a.InfoContext(calldepth.ContextWithSampleDecision(context.Background(), false), "dropped")
+
+	if buf.Len() != 0 {
+		t.Errorf("record logged with a context forcing it to be dropped: %s", buf)
+	}
+}
+
+// at returns a context logging records at the given offset from a fixed time, for WithContextEventTime.
+func at(offset time.Duration) context.Context {
+	return calldepth.ContextWithEventTime(context.Background(), time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC).Add(offset))
+}
+
+func TestWithDebounce(t *testing.T) {
+	a, buf := newJSON(
+		calldepth.WithContextEventTime(),
+		calldepth.WithDebounce(time.Second, func(r slog.Record) string { return r.Message }),
+	)
+
+	a.InfoContext(at(0), "retrying")
+	a.InfoContext(at(100*time.Millisecond), "retrying")
+	a.InfoContext(at(200*time.Millisecond), "other")
+	a.InfoContext(at(300*time.Millisecond), "retrying")
+	a.InfoContext(at(time.Second), "retrying")
+
+	out := records(t, buf)
+	if got, want := messages(out), []string{"retrying", "other", "retrying"}; !slices.Equal(got, want) {
+		t.Fatalf("messages = %v, want %v", got, want)
+	}
+
+	if out[2][calldepth.SuppressedKey] != float64(2) {
+		t.Errorf("suppressed = %v, want 2", out[2][calldepth.SuppressedKey])
+	}
+}
+
+func TestWithDedupCacheSize(t *testing.T) {
+	a, buf := newJSON(
+		calldepth.WithContextEventTime(),
+		calldepth.WithDebounce(time.Second, func(r slog.Record) string { return r.Message }),
+		calldepth.WithDedupCacheSize(2),
+	)
+
+	a.InfoContext(at(0), "a")
+	a.InfoContext(at(10*time.Millisecond), "b")
+	a.InfoContext(at(20*time.Millisecond), "c") // evicts a, logged the longest ago.
+	a.InfoContext(at(30*time.Millisecond), "b")
+	a.InfoContext(at(40*time.Millisecond), "a") // evicts b.
+	a.InfoContext(at(50*time.Millisecond), "c")
+
+	if got, want := messages(records(t, buf)), []string{"a", "b", "c", "a"}; !slices.Equal(got, want) {
+		t.Errorf("messages = %v, want %v: the recent keys are still debounced", got, want)
+	}
+}
+
+func TestWithSamplingReport(t *testing.T) {
+	a, buf := newJSON(
+		calldepth.WithSampler(func(_ context.Context, level slog.Level) bool { return level >= slog.LevelInfo }),
+		calldepth.WithSamplingReport(20*time.Millisecond),
+	)
+
+	for range [3]struct{}{} {
+		a.Debug("dropped")
+	}
+
+	a.Info("kept")
+	time.Sleep(25 * time.Millisecond)
+	a.Info("kept")
+
+	out := records(t, buf)
+	if got, want := messages(out), []string{"kept", calldepth.SamplingReportMessage, "kept"}; !slices.Equal(got, want) {
+		t.Fatalf("messages = %v, want %v", got, want)
+	}
+
+	kept, _ := out[1]["kept"].(map[string]any)
+	dropped, _ := out[1]["dropped"].(map[string]any)
+
+	if kept["INFO"] != float64(2) || dropped["DEBUG"] != float64(3) {
+		t.Errorf("report = %v, want 2 info records kept and 3 debug records dropped", out[1])
+	}
+}
+
+func TestLoadSampler(t *testing.T) {
+	var load float64
+
+	// the thresholds are sorted by load.
+	sampler := calldepth.LoadSampler(func() float64 { return load },
+		calldepth.LoadThreshold{Load: 100, Ratio: 0},
+		calldepth.LoadThreshold{Load: 10, Ratio: 0.5},
+	)
+
+	kept := func() int {
+		n := 0
+
+		for i := 0; i < 1000; i++ {
+			if sampler(context.Background(), slog.LevelInfo) {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	load = 5
+	if n := kept(); n != 1000 {
+		t.Errorf("kept %d of 1000 records below the lowest threshold, want all", n)
+	}
+
+	load = 50
+	if n := kept(); n < 400 || n > 600 {
+		t.Errorf("kept %d of 1000 records at a ratio of 0.5, want about 500", n)
+	}
+
+	load = 100
+	if n := kept(); n != 0 {
+		t.Errorf("kept %d of 1000 records at a ratio of 0, want none", n)
+	}
+}
+
+func TestWithLevelSampling(t *testing.T) {
+	a, rec := newRecorder(calldepth.WithLevelSampling(map[slog.Level]float64{
+		slog.LevelDebug: 0,
+		slog.LevelInfo:  0.5,
+		slog.LevelWarn:  1,
+	}))
+
+	for i := 0; i < 1000; i++ {
+		a.Debug("m")
+		a.Info("m")
+		a.Warn("m")
+		a.Error("m")
+	}
+
+	kept := map[slog.Level]int{}
+	for _, record := range rec.records {
+		kept[record.Level]++
+	}
+
+	if kept[slog.LevelDebug] != 0 || kept[slog.LevelWarn] != 1000 || kept[slog.LevelError] != 1000 {
+		t.Errorf("kept = %v, want no debug record and every warn and error record", kept)
+	}
+
+	if n := kept[slog.LevelInfo]; n < 400 || n > 600 {
+		t.Errorf("kept %d of 1000 info records at a ratio of 0.5, want about 500", n)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+const (
+	// badKey is the key given by slog to the arguments without a key.
+	badKey = "!BADKEY"
+
+	// RedactedValue replaces the values of the attributes redacted by WithRedactKeys.
+	RedactedValue = "[REDACTED]"
+)
+
+// WithSanitizeMessage escapes control characters, e.g. newlines, and the unicode line and paragraph separators in the
+// message and in string attribute values, so that user supplied input cannot forge log lines. The values of errors, of
+// fmt.Stringer and of slog.LogValuer resolving to either are logged as their escaped string form.
+func WithSanitizeMessage() Option {
+	return func(a *adapter) {
+		a.message = append(a.message, escapeControl)
+		a.replacer = append(a.replacer, func(attr slog.Attr) slog.Attr {
+			value := attr.Value.Resolve()
+
+			switch value.Kind() { //nolint:exhaustive // only the kinds holding text are escaped.
+			case slog.KindString:
+				attr.Value = slog.StringValue(escapeControl(value.String()))
+			case slog.KindAny:
+				switch v := value.Any().(type) {
+				case error:
+					attr.Value = slog.StringValue(escapeControl(v.Error()))
+				case fmt.Stringer:
+					attr.Value = slog.StringValue(escapeControl(v.String()))
+				}
+			}
+
+			return attr
+		})
+	}
+}
+
+// WithBadKeyPlaceholder replaces the `!BADKEY` key given by slog to the arguments without a key, e.g. the last of an
+// odd number of arguments, with key, including in groups and in the attributes added with With. It runs ahead of the
+// other options rewriting keys, whatever their order.
+func WithBadKeyPlaceholder(key string) Option {
+	return func(a *adapter) {
+		a.replacer = append([]func(slog.Attr) slog.Attr{func(attr slog.Attr) slog.Attr {
+			if attr.Key == badKey {
+				attr.Key = key
+			}
+
+			return attr
+		}}, a.replacer...)
+	}
+}
+
+// WithRedactKeys replaces the values of the attributes with the given keys, including group members, with
+// RedactedValue, e.g. for "password" or "token". Redaction applies to all the attributes of a record, those of the
+// call, of With, of the context extractors and of the hooks, and runs after the other rewrites, so the keys are the
+// keys as logged, e.g. with the prefix of WithNamespace. The records kept by WithReplayBuffer and those passed to the
+// sink of WithDroppedSink are redacted too.
+func WithRedactKeys(keys ...string) Option {
+	return func(a *adapter) {
+		if a.redact == nil {
+			a.redact = make(map[string]bool, len(keys))
+		}
+
+		for _, key := range keys {
+			a.redact[key] = true
+		}
+	}
+}
+
+// WithSanitizeKeys replaces the characters of attribute keys, including keys of group members, that are not allowed by
+// IsKeyChar with replacement.
+func WithSanitizeKeys(replacement rune) Option {
+	return WithSanitizeKeysFunc(replacement, IsKeyChar)
+}
+
+// WithSanitizeKeysFunc replaces the characters of attribute keys, including keys of group members, for which allowed
+// returns false with replacement.
+func WithSanitizeKeysFunc(replacement rune, allowed func(r rune) bool) Option {
+	return func(a *adapter) {
+		a.replacer = append(a.replacer, func(attr slog.Attr) slog.Attr {
+			attr.Key = sanitizeKey(attr.Key, replacement, allowed)
+
+			return attr
+		})
+	}
+}
+
+// IsKeyChar reports whether r is allowed in an attribute key by WithSanitizeKeys, i.e. is a letter, a digit, an
+// underscore or a hyphen.
+func IsKeyChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+// sanitizeKey replaces the characters of key that are not allowed with replacement.
+func sanitizeKey(key string, replacement rune, allowed func(r rune) bool) string {
+	if strings.IndexFunc(key, func(r rune) bool { return !allowed(r) }) < 0 {
+		return key
+	}
+
+	return strings.Map(func(r rune) rune {
+		if allowed(r) {
+			return r
+		}
+
+		return replacement
+	}, key)
+}
+
+// escapeControl replaces control characters and the unicode line and paragraph separators with their escaped form, e.g.
+// a newline with `\n`.
+func escapeControl(s string) string {
+	if strings.IndexFunc(s, escaped) < 0 {
+		return s
+	}
+
+	var b strings.Builder
+
+	b.Grow(len(s) + 8)
+
+	for _, r := range s {
+		switch r {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if escaped(r) {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// escaped reports whether r is escaped by escapeControl, i.e. is a control character, U+2028 or U+2029.
+func escaped(r rune) bool {
+	return unicode.IsControl(r) || r == '\u2028' || r == '\u2029'
+}
+
+// redactValue replaces the value of the attribute with RedactedValue if its key is redacted by WithRedactKeys.
+func (a *adapter) redactValue(attr slog.Attr) slog.Attr {
+	if a.redact[attr.Key] {
+		attr.Value = slog.StringValue(RedactedValue)
+	}
+
+	return attr
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// address is a fmt.Stringer logging user supplied input.
+	address struct {
+		line string
+	}
+
+	// failure is a slog.LogValuer resolving to an error.
+	failure struct {
+		reason string
+	}
+)
+
+func (a address) String() string { return a.line }
+
+func (f failure) LogValue() slog.Value { return slog.AnyValue(errors.New(f.reason)) }
+
+func TestWithBadKeyPlaceholder(t *testing.T) {
+	// the placeholder is given ahead of the namespace, although it comes after it.
+	a, buf := newJSON(calldepth.WithNamespace("app"), calldepth.WithBadKeyPlaceholder("extra"))
+
+	// passed as slices, for vet not to report the missing keys.
+	with, members := []any{"orphan0"}, []any{"orphan1"}
+	a.With(with...).Info("m", slog.Group("g", members...))
+
+	if strings.Contains(buf.String(), "BADKEY") {
+		t.Errorf("bad key logged: %s", buf)
+	}
+
+	record := single(t, buf)
+	if record["app.extra"] != "orphan0" {
+		t.Errorf("record = %v, want the argument of With under app.extra", record)
+	}
+
+	if g, _ := record["app.g"].(map[string]any); g["extra"] != "orphan1" {
+		t.Errorf("app.g = %v, want the group member under extra", record["app.g"])
+	}
+}
+
+func TestWithRedactKeys(t *testing.T) {
+	// the keys as logged: prefixed at the top level only.
+	a, buf := newJSON(calldepth.WithNamespace("app"), calldepth.WithRedactKeys("app.token", "token"))
+
+	a.With("token", "secret0").Info("m", "token", "secret1", slog.Group("auth", "token", "secret2"))
+
+	out := buf.String()
+	if strings.Contains(out, "secret") {
+		t.Errorf("secret logged: %s", out)
+	}
+
+	if got := strings.Count(out, calldepth.RedactedValue); got != 3 {
+		t.Errorf("got %d redacted values, want 3: %s", got, out)
+	}
+}
+
+func TestWithRedactKeysReplay(t *testing.T) {
+	replay, buffer := calldepth.WithReplayBuffer(4)
+	a, _ := newJSON(replay, calldepth.WithRedactKeys("token"))
+
+	a.With("token", "secret1").Info("m", "token", "secret2")
+
+	var out bytes.Buffer
+	if err := buffer.Replay(slog.NewTextHandler(&out, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(out.String(), "secret") {
+		t.Errorf("secret replayed: %s", out.String())
+	}
+}
+
+func TestWithRedactKeysDropped(t *testing.T) {
+	var out bytes.Buffer
+
+	a, _ := newJSON(
+		calldepth.WithSampler(func(context.Context, slog.Level) bool { return false }),
+		calldepth.WithDroppedSink(slog.NewTextHandler(&out, nil)),
+		calldepth.WithRedactKeys("token"),
+	)
+
+	a.With("token", "secret1").Info("m", "token", "secret2")
+
+	if !strings.Contains(out.String(), "msg=m") || strings.Contains(out.String(), "secret") {
+		t.Errorf("dropped record not redacted: %s", out.String())
+	}
+}
+
+func TestWithSanitizeMessage(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSanitizeMessage())
+
+	a.Info("login\nlevel=ERROR msg=forged", "user", "alice\r\nforged", "n", 1)
+
+	record := single(t, buf)
+	if record["msg"] != `login\nlevel=ERROR msg=forged` || record["user"] != `alice\r\nforged` {
+		t.Errorf("msg = %q, user = %q, want the control characters escaped", record["msg"], record["user"])
+	}
+}
+
+func TestWithSanitizeMessageValues(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSanitizeMessage())
+
+	a.Info("m",
+		"err", errors.New("failed\nlevel=ERROR"),
+		"addr", address{line: "street\r\nforged"},
+		"failure", failure{reason: "valued\tforged"},
+		"n", 1,
+	)
+
+	record := single(t, buf)
+	if record["err"] != `failed\nlevel=ERROR` || record["addr"] != `street\r\nforged` ||
+		record["failure"] != `valued\tforged` || record["n"] != float64(1) {
+		t.Errorf("record = %v, want the string forms escaped and the number kept", record)
+	}
+}
+
+func TestWithSanitizeMessageSeparators(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSanitizeMessage())
+
+	a.Info("line\u2028forged", "user", "alice\u2029forged")
+
+	record := single(t, buf)
+	if record["msg"] != `line\u2028forged` || record["user"] != `alice\u2029forged` {
+		t.Errorf("msg = %q, user = %q, want the separators escaped", record["msg"], record["user"])
+	}
+}
+
+func TestWithSanitizeKeys(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSanitizeKeys('_'))
+
+	a.With("user id", 1).Info("m", slog.Group("http req", "status.code", 200))
+
+	record := single(t, buf)
+	group, _ := record["http_req"].(map[string]any)
+
+	if record["user_id"] != float64(1) || group["status_code"] != float64(200) {
+		t.Errorf("record = %v, want the keys sanitized, including in groups", record)
+	}
+}
+
+func TestWithSanitizeKeysFunc(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSanitizeKeysFunc('-', func(r rune) bool { return r != '.' }))
+
+	a.Info("m", "user.name", "alice")
+
+	if record := single(t, buf); record["user-name"] != "alice" {
+		t.Errorf("record = %v, want the dots replaced", record)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+type (
+	// schemaHandler checks that the records it handles have the required attributes at the top level. Once a group is
+	// opened, only the attributes added with WithAttrs before it remain at the top level.
+	schemaHandler struct {
+		next     slog.Handler
+		required []string
+		present  []string // present are the keys added with WithAttrs.
+		grouped  bool     // grouped is set once a group is opened, nesting the attributes added afterwards.
+	}
+)
+
+var (
+	// ErrMissingAttrs is reported by WithSchema when a record is missing required attributes.
+	ErrMissingAttrs = errors.New("calldepth: record missing required attributes")
+)
+
+// WithSchema reports ErrMissingAttrs to the error handler when a record is logged without a top level attribute for
+// each of the required keys, e.g. "request_id", to catch records drifting from the log schema. The attributes added
+// with With, the global attributes and those extracted from the context count; the record is logged either way.
+func WithSchema(required []string) Option {
+	return func(a *adapter) {
+		a.required = append(a.required, required...)
+	}
+}
+
+func (h *schemaHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *schemaHandler) Handle(ctx context.Context, record slog.Record) error {
+	var missing []string
+
+	for _, key := range h.required {
+		if slices.Contains(h.present, key) {
+			continue
+		}
+
+		found := false
+
+		// once a group is open, the attributes of the record are nested in it.
+		if !h.grouped {
+			record.Attrs(func(attr slog.Attr) bool {
+				found = attr.Key == key
+
+				return !found
+			})
+		}
+
+		if !found {
+			missing = append(missing, key)
+		}
+	}
+
+	err := h.next.Handle(ctx, record)
+	if len(missing) > 0 {
+		err = errors.Join(err, fmt.Errorf("%w: %s in %q", ErrMissingAttrs, strings.Join(missing, ", "), record.Message))
+	}
+
+	return err
+}
+
+func (h *schemaHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+
+	if !h.grouped {
+		c.present = h.present[:len(h.present):len(h.present)]
+
+		for _, attr := range attrs {
+			c.present = append(c.present, attr.Key)
+		}
+	}
+
+	return &c
+}
+
+func (h *schemaHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+	c.grouped = c.grouped || name != ""
+
+	return &c
+}
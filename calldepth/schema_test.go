@@ -0,0 +1,59 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"errors"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestWithSchema(t *testing.T) {
+	var errs []error
+
+	a, buf := newJSON(
+		calldepth.WithSchema([]string{"request_id", "user"}),
+		calldepth.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+
+	a = a.With("user", "alice")
+
+	a.Info("complete", "request_id", "r1")
+	a.Info("missing")
+	a.WithGroup("g").Info("grouped", "request_id", "r2")
+
+	if got := len(records(t, buf)); got != 3 {
+		t.Errorf("logged %d records, want 3: records missing attributes are logged", got)
+	}
+
+	if len(errs) != 2 {
+		t.Fatalf("reported %d errors, want 2: %v", len(errs), errs)
+	}
+
+	for _, err := range errs {
+		if !errors.Is(err, calldepth.ErrMissingAttrs) {
+			t.Errorf("error = %v, want ErrMissingAttrs", err)
+		}
+	}
+
+	if want := `calldepth: record missing required attributes: request_id in "missing"`; errs[0].Error() != want {
+		t.Errorf("error = %q, want %q", errs[0], want)
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxCallerFrames is the maximum number of frames looked at to find the source of a record.
+	maxCallerFrames = 32
+
+	// CallerKey is the key of the attribute holding the caller added by WithAdaptiveSource.
+	CallerKey = "caller"
+
+	// CallersKey is the key of the attribute holding the frames added by WithCallerChain.
+	CallersKey = "callers"
+)
+
+type (
+	// depthKey is the context key for the depth delta.
+	depthKey struct{}
+)
+
+var (
+	// modulePath is the path of this module, whose frames are skipped by WithUserCodeSource.
+	modulePath = strings.TrimSuffix(reflect.TypeOf(adapter{}).PkgPath(), "/calldepth")
+
+	// gorootSrc is the directory of the sources of the standard library, or empty for a binary built with -trimpath.
+	gorootSrc = goroot()
+
+	// mainPath is the path of the main module, never taken for the standard library.
+	mainPath = mainModule()
+)
+
+// WithUserCodeSource finds the source of a record by walking up the stack past the frames of the standard library, of
+// this module and of the packages with the given path prefixes, e.g. logging wrappers of 3rd party libraries, instead
+// of skipping a fixed number of frames.
+//
+// Standard library frames are recognized by their file being in GOROOT. For a binary built with -trimpath, they are
+// recognized by the lack of a dot in the first element of their package path instead, except for the packages of the
+// main module.
+func WithUserCodeSource(skip ...string) Option {
+	return func(a *adapter) {
+		a.userCode = append([]string{modulePath + "/"}, skip...)
+	}
+}
+
+// WithSkipAnonymous attributes the records logged from anonymous functions to the nearest named function up the stack,
+// instead of e.g. `main.run.func1`. Function literals are recognized by the names the compiler gives them, ending in
+// `funcN` or, when nested, in a number.
+func WithSkipAnonymous() Option {
+	return func(a *adapter) {
+		a.skipAnon = true
+	}
+}
+
+// WithContextDepth adds the delta stored with ContextWithDepthDelta to the call depth, so that framework integrations
+// calling handlers through a varying number of frames can correct the source per request. It has no effect with
+// WithUserCodeSource.
+func WithContextDepth() Option {
+	return func(a *adapter) {
+		a.ctxDepth = true
+	}
+}
+
+// ContextWithDepthDelta returns a copy of the context carrying the number of frames to skip on top of the call depth
+// of adapters created with WithContextDepth.
+func ContextWithDepthDelta(ctx context.Context, delta int) context.Context {
+	return context.WithValue(ctx, depthKey{}, delta)
+}
+
+// WithAdaptiveSource adds the caller, as `file:line`, to the records below stackFromLevel, and the stack trace from the
+// caller up to the records at or above it, to give the detail needed for each severity.
+func WithAdaptiveSource(stackFromLevel slog.Level) Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			if record.PC == 0 {
+				return
+			}
+
+			if record.Level < stackFromLevel {
+				frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+				record.AddAttrs(slog.String(CallerKey, frame.File+":"+strconv.Itoa(frame.Line)))
+
+				return
+			}
+
+			record.AddAttrs(slog.String(StackTraceKey, formatStack(stackFrom(record.PC))))
+		})
+	}
+}
+
+// WithSourceLevelVar looks up the source of the records at or above the level of the returned variable only, initially
+// debug level, so that operators can turn the cost of runtime.Callers off for the lower levels at runtime, e.g. by
+// setting it to warn level under load. DeferLevel still looks up the source, the level being unknown until the record
+// is logged, and drops it then.
+func WithSourceLevelVar() (Option, *slog.LevelVar) {
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelDebug)
+
+	return func(a *adapter) {
+		a.sourceLevel = level
+	}, level
+}
+
+// sourceEnabled reports whether the source of a record at the given level is looked up.
+func (a *adapter) sourceEnabled(level slog.Level) bool {
+	return a.sourceLevel == nil || level >= a.sourceLevel.Level()
+}
+
+// WithCallerChain adds the n innermost frames of the stack of the records at or above minLevel, starting at their
+// source, as `callers`, a list of `function file:line` strings. It costs less than a stack trace and tells more than
+// the source alone, e.g. which caller passed a bad argument. With n at 0 or below, no frames are added.
+func WithCallerChain(n int, minLevel slog.Level) Option {
+	n = max(n, 0)
+
+	return func(a *adapter) {
+		if n == 0 {
+			return
+		}
+
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			if record.Level < minLevel || record.PC == 0 {
+				return
+			}
+
+			chain := make([]string, 0, n)
+			frames := runtime.CallersFrames(stackFrom(record.PC))
+
+			for len(chain) < n {
+				frame, more := frames.Next()
+				chain = append(chain, frame.Function+" "+frame.File+":"+strconv.Itoa(frame.Line))
+
+				if !more {
+					break
+				}
+			}
+
+			record.AddAttrs(slog.Any(CallersKey, chain))
+		})
+	}
+}
+
+// stackFrom returns the program counters of the current goroutine's stack, starting at pc. It must be called while
+// the frame of pc is still on the stack, e.g. by a hook.
+func stackFrom(pc uintptr) []uintptr {
+	pcs := make([]uintptr, maxCallerFrames)
+	n := runtime.Callers(2, pcs)
+
+	for i := 0; i < n; i++ {
+		if pcs[i] == pc {
+			return pcs[i:n]
+		}
+	}
+
+	return []uintptr{pc}
+}
+
+// formatStack formats the frames of the program counters, one function and its `file:line` per frame.
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+
+	frames := runtime.CallersFrames(pcs)
+
+	for {
+		frame, more := frames.Next()
+
+		b.WriteString(frame.Function)
+		b.WriteString("\n\t")
+		b.WriteString(frame.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(frame.Line))
+
+		if !more {
+			break
+		}
+
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// caller returns the program counter of the source of the record. It must be called directly by log or logattrs.
+func (a *adapter) caller(ctx context.Context) uintptr {
+	if a.userCode != nil {
+		return a.userCaller()
+	}
+
+	var pcs [1]uintptr
+
+	// skip caller itself on top of the frames skipped by the depth.
+	skip := a.depth + 1
+
+	if a.ctxDepth {
+		delta, _ := ctx.Value(depthKey{}).(int)
+		skip += delta
+	}
+
+	if !a.skipAnon {
+		runtime.Callers(skip, pcs[:])
+
+		return pcs[0]
+	}
+
+	var frames [maxCallerFrames]uintptr
+
+	n := runtime.Callers(skip, frames[:])
+
+	for i := 0; i < n; i++ {
+		frame, _ := runtime.CallersFrames(frames[i : i+1]).Next()
+		if !isAnonymous(frame.Function) {
+			return frames[i]
+		}
+	}
+
+	return frames[0]
+}
+
+// userCaller returns the program counter of the first frame that is not skipped by WithUserCodeSource.
+func (a *adapter) userCaller() uintptr {
+	var pcs [maxCallerFrames]uintptr
+
+	n := runtime.Callers(2, pcs[:])
+
+	for i := 0; i < n; i++ {
+		frame, _ := runtime.CallersFrames(pcs[i : i+1]).Next()
+		if !a.skipFrame(frame) {
+			return pcs[i]
+		}
+	}
+
+	return 0
+}
+
+// skipFrame reports whether the frame belongs to a package skipped by WithUserCodeSource.
+func (a *adapter) skipFrame(frame runtime.Frame) bool {
+	if isStdlib(frame) {
+		return true
+	}
+
+	if a.skipAnon && isAnonymous(frame.Function) {
+		return true
+	}
+
+	for _, prefix := range a.userCode {
+		if strings.HasPrefix(frame.Function, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isStdlib reports whether the frame belongs to a standard library package.
+func isStdlib(frame runtime.Frame) bool {
+	if gorootSrc != "" {
+		return strings.HasPrefix(frame.File, gorootSrc)
+	}
+
+	if mainPath != "" && (strings.HasPrefix(frame.Function, mainPath+"/") || strings.HasPrefix(frame.Function, mainPath+".")) {
+		return false
+	}
+
+	first, _, nested := strings.Cut(frame.Function, "/")
+	if !nested {
+		// the package name ends at the first dot, e.g. runtime.goexit or main.main.
+		first, _, _ = strings.Cut(first, ".")
+
+		return first != "main"
+	}
+
+	return !strings.Contains(first, ".")
+}
+
+// goroot returns the directory of the sources of the standard library as found in the file of its frames, which may
+// differ from runtime.GOROOT on the machine running the binary, by looking up the file of a standard library function.
+func goroot() string {
+	file, _ := runtime.FuncForPC(reflect.ValueOf(strings.Cut).Pointer()).FileLine(0)
+
+	root, ok := strings.CutSuffix(file, "strings/strings.go")
+	if !ok || !strings.HasSuffix(root, "/src/") {
+		return ""
+	}
+
+	return root
+}
+
+// mainModule returns the path of the main module, if known.
+func mainModule() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	return info.Main.Path
+}
+
+// isAnonymous reports whether the fully qualified function name is the name of a function literal, e.g.
+// `main.run.func1` or `main.run.func1.2`.
+func isAnonymous(function string) bool {
+	name := function[strings.LastIndex(function, "/")+1:]
+	if _, name, _ = strings.Cut(name, "."); !strings.Contains(name, ".") {
+		return false
+	}
+
+	last := name[strings.LastIndex(name, ".")+1:]
+	last = strings.TrimPrefix(last, "func")
+
+	_, err := strconv.Atoi(last)
+
+	return err == nil
+}
+
+// WithStructuredCaller adds the caller as the top level `file`, `line` and `func` attributes, using the program
+// counter already captured for the record.
+func WithStructuredCaller() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			if record.PC == 0 {
+				return
+			}
+
+			frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+
+			record.AddAttrs(
+				slog.String("file", frame.File),
+				slog.Int("line", frame.Line),
+				slog.String("func", frame.Function),
+			)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestIsStdlib(t *testing.T) {
+	if gorootSrc == "" {
+		t.Skip("built with -trimpath")
+	}
+
+	tests := []struct {
+		name  string
+		frame runtime.Frame
+		want  bool
+	}{
+		{"stdlib", runtime.Frame{Function: "net/http.HandlerFunc.ServeHTTP", File: gorootSrc + "net/http/server.go"}, true},
+		{"runtime", runtime.Frame{Function: "runtime.goexit", File: gorootSrc + "runtime/asm_amd64.s"}, true},
+		{"module", runtime.Frame{Function: "github.com/acme/app.Run", File: "/src/app/run.go"}, false},
+		{"module without dot", runtime.Frame{Function: "myservice/handlers.Serve", File: "/src/myservice/handlers/serve.go"}, false},
+		{"main", runtime.Frame{Function: "main.main", File: "/src/myservice/main.go"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStdlib(tt.frame); got != tt.want {
+				t.Errorf("isStdlib(%s) = %v, want %v", tt.frame.Function, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+func TestWithStructuredCaller(t *testing.T) {
+	a, buf := newJSON(calldepth.WithStructuredCaller())
+
+	_, file, line, _ := runtime.Caller(0)
+	a.Info("m")
+
+	record := single(t, buf)
+	if record["file"] != file || record["line"] != float64(line+1) {
+		t.Errorf("file = %v, line = %v, want %s:%d", record["file"], record["line"], file, line+1)
+	}
+
+	if record["func"] != "go.breu.io/slog-utils/calldepth_test.TestWithStructuredCaller" {
+		t.Errorf("func = %v, want the test", record["func"])
+	}
+}
+
+// logThrough logs through a wrapper frame, such as a framework calling the handler of a request.
+func logThrough(ctx context.Context, a calldepth.Adapter) {
+	a.InfoContext(ctx, "m")
+}
+
+func TestWithContextDepth(t *testing.T) {
+	a, buf := newJSON(calldepth.WithContextDepth())
+
+	_, _, line, _ := runtime.Caller(0)
+	logThrough(calldepth.ContextWithDepthDelta(context.Background(), 1), a)
+	logThrough(context.Background(), a)
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	if got := sourceLine(t, out[0]); got != line+1 {
+		t.Errorf("source line = %d, want %d, the caller of the wrapper", got, line+1)
+	}
+
+	if source, _ := out[1][slog.SourceKey].(map[string]any); source["function"] != "go.breu.io/slog-utils/calldepth_test.logThrough" {
+		t.Errorf("source = %v, want the wrapper without a delta", source)
+	}
+}
+
+func TestWithAdaptiveSource(t *testing.T) {
+	a, buf := newJSON(calldepth.WithAdaptiveSource(slog.LevelError))
+
+	_, file, line, _ := runtime.Caller(0)
+	a.Warn("slow")
+	a.Error("failed")
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	if want := file + ":" + strconv.Itoa(line+1); out[0][calldepth.CallerKey] != want || out[0][calldepth.StackTraceKey] != nil {
+		t.Errorf("warn record = %v, want the caller %s only", out[0], want)
+	}
+
+	stack, _ := out[1][calldepth.StackTraceKey].(string)
+	if !strings.HasPrefix(stack, "go.breu.io/slog-utils/calldepth_test.TestWithAdaptiveSource\n") || out[1][calldepth.CallerKey] != nil {
+		t.Errorf("error record = %v, want a stack trace starting at the test only", out[1])
+	}
+}
+
+func TestWithSkipAnonymous(t *testing.T) {
+	a, buf := newJSON(calldepth.WithSkipAnonymous())
+
+	_, _, line, _ := runtime.Caller(0)
+	func() {
+		func() { a.Info("m") }()
+	}()
+
+	source, _ := single(t, buf)[slog.SourceKey].(map[string]any)
+	if want := "go.breu.io/slog-utils/calldepth_test.TestWithSkipAnonymous"; source["function"] != want {
+		t.Errorf("function = %v, want %v", source["function"], want)
+	}
+
+	if source["line"] != float64(line+3) {
+		t.Errorf("line = %v, want %d, the call to the outer closure", source["line"], line+3)
+	}
+}
+
+func TestWithCallerChain(t *testing.T) {
+	a, buf := newJSON(calldepth.WithCallerChain(2, slog.LevelInfo))
+
+	_, file, line, _ := runtime.Caller(0)
+	logThrough(context.Background(), a)
+	a.Debug("below the level")
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	callers, _ := out[0][calldepth.CallersKey].([]any)
+	if len(callers) != 2 {
+		t.Fatalf("callers = %v, want 2 frames", out[0][calldepth.CallersKey])
+	}
+
+	if first, _ := callers[0].(string); !strings.HasPrefix(first, "go.breu.io/slog-utils/calldepth_test.logThrough ") {
+		t.Errorf("first caller = %q, want the source of the record", first)
+	}
+
+	if want := fmt.Sprintf("go.breu.io/slog-utils/calldepth_test.TestWithCallerChain %s:%d", file, line+1); callers[1] != want {
+		t.Errorf("second caller = %q, want %q", callers[1], want)
+	}
+
+	if _, ok := out[1][calldepth.CallersKey]; ok {
+		t.Errorf("record = %v, want no callers below the level", out[1])
+	}
+}
+
+func TestWithSourceLevelVar(t *testing.T) {
+	option, level := calldepth.WithSourceLevelVar()
+	a, buf := newJSON(option)
+
+	a.Info("m")
+	level.Set(slog.LevelWarn)
+	a.Info("m")
+	a.Warn("m")
+	level.Set(slog.LevelDebug)
+	a.Info("m")
+
+	out := records(t, buf)
+	if len(out) != 4 {
+		t.Fatalf("got %d records, want 4", len(out))
+	}
+
+	for i, want := range []bool{true, false, true, true} {
+		if _, ok := out[i][slog.SourceKey]; ok != want {
+			t.Errorf("record %d has source = %v, want %v", i, ok, want)
+		}
+	}
+}
+
+func TestWithCallerChainEmpty(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		a, buf := newJSON(calldepth.WithCallerChain(n, slog.LevelInfo))
+
+		a.Info("m")
+
+		if record := single(t, buf); record[calldepth.CallersKey] != nil {
+			t.Errorf("record = %v with n = %d, want no callers", record, n)
+		}
+	}
+}
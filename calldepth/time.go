@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// eventTimeKey is the context key for the event time.
+	eventTimeKey struct{}
+)
+
+const (
+	// ElapsedKey is the key of the attribute added by WithElapsedAttr.
+	ElapsedKey = "elapsed"
+)
+
+// WithTimeTruncate truncates the time of every record to a multiple of d, e.g. time.Second, to reduce its
+// precision.
+func WithTimeTruncate(d time.Duration) Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			record.Time = record.Time.Truncate(d)
+		})
+	}
+}
+
+// WithMonotonicTime keeps the time of every record at or after the time of the previous record, moving it forward
+// when the wall clock went back, e.g. on an NTP adjustment, so that the logs stay ordered. It clamps the time as set by
+// the options before it, e.g. WithContextEventTime if it comes first.
+func WithMonotonicTime() Option {
+	return func(a *adapter) {
+		var last atomic.Int64
+
+		a.hooks = append(a.hooks, func(_ context.Context, record *slog.Record) {
+			for {
+				prev, now := last.Load(), record.Time.UnixNano()
+				if now < prev {
+					record.Time = record.Time.Add(time.Duration(prev - now))
+
+					return
+				}
+
+				if last.CompareAndSwap(prev, now) {
+					return
+				}
+			}
+		})
+	}
+}
+
+// WithElapsedAttr adds the time elapsed from the start time stored in the context under startKey, e.g. by a
+// middleware at the start of a request, to the time of every record, as `elapsed`, to follow the progress of the
+// request. Records logged with a context without a time.Time under startKey are not changed.
+func WithElapsedAttr(startKey any) Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(ctx context.Context, record *slog.Record) {
+			if start, ok := ctx.Value(startKey).(time.Time); ok {
+				record.AddAttrs(slog.Duration(ElapsedKey, record.Time.Sub(start)))
+			}
+		})
+	}
+}
+
+// WithContextEventTime uses the time stored with ContextWithEventTime as the time of the records, e.g. when replaying
+// or ingesting events, instead of the current time. Records logged with a context without one are not changed.
+func WithContextEventTime() Option {
+	return func(a *adapter) {
+		a.hooks = append(a.hooks, func(ctx context.Context, record *slog.Record) {
+			if t, ok := EventTimeFromContext(ctx); ok {
+				record.Time = t
+			}
+		})
+	}
+}
+
+// ContextWithEventTime returns a copy of the context carrying the time at which the logged event happened.
+func ContextWithEventTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, eventTimeKey{}, t)
+}
+
+// EventTimeFromContext returns the time stored with ContextWithEventTime, if any.
+func EventTimeFromContext(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(eventTimeKey{}).(time.Time)
+
+	return t, ok
+}
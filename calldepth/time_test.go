@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	startKey struct{}
+)
+
+func TestWithTimeTruncate(t *testing.T) {
+	a, rec := newRecorder(calldepth.WithTimeTruncate(time.Second))
+
+	before := time.Now().Truncate(time.Second)
+	a.Info("m")
+
+	if got := rec.records[0].Time; got.Nanosecond() != 0 || got.Before(before) {
+		t.Errorf("time = %v, want a time truncated to the second", got)
+	}
+}
+
+func TestWithContextEventTime(t *testing.T) {
+	a, rec := newRecorder(calldepth.WithContextEventTime())
+	event := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	a.InfoContext(calldepth.ContextWithEventTime(context.Background(), event), "replayed")
+
+	before := time.Now()
+	a.InfoContext(context.Background(), "live")
+
+	if got := rec.records[0].Time; !got.Equal(event) {
+		t.Errorf("time = %v, want the event time %v", got, event)
+	}
+
+	if got := rec.records[1].Time; got.Before(before) {
+		t.Errorf("time = %v, want the current time without an event time", got)
+	}
+}
+
+func TestWithMonotonicTime(t *testing.T) {
+	a, rec := newRecorder(calldepth.WithContextEventTime(), calldepth.WithMonotonicTime())
+
+	a.InfoContext(at(time.Second), "m")
+	a.InfoContext(at(0), "clock went back")
+	a.InfoContext(at(2*time.Second), "m")
+
+	for i, offset := range []time.Duration{time.Second, time.Second, 2 * time.Second} {
+		want := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC).Add(offset)
+		if got := rec.records[i].Time; !got.Equal(want) {
+			t.Errorf("record %d time = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestWithElapsedAttr(t *testing.T) {
+	a, buf := newJSON(calldepth.WithContextEventTime(), calldepth.WithElapsedAttr(startKey{}))
+	start := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	a.InfoContext(context.WithValue(at(1500*time.Millisecond), startKey{}, start), "step")
+	a.InfoContext(at(0), "no start")
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	if got := out[0][calldepth.ElapsedKey]; got != float64(1500*time.Millisecond) {
+		t.Errorf("elapsed = %v, want 1.5s from the start of the context", got)
+	}
+
+	if _, ok := out[1][calldepth.ElapsedKey]; ok {
+		t.Errorf("record = %v, want no elapsed time without a start", out[1])
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package calldepth
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+type (
+	// traceContextKey is the context key for the trace stored with ContextWithTrace.
+	traceContextKey struct{}
+
+	// traceIDs are the ids of a trace and of a span in it.
+	traceIDs struct {
+		trace string
+		span  string
+	}
+)
+
+const (
+	// TraceIDKey is the default key of the trace id attribute added by WithTraceKeys.
+	TraceIDKey = "trace_id"
+
+	// SpanIDKey is the default key of the span id attribute added by WithTraceKeys.
+	SpanIDKey = "span_id"
+
+	// TraceparentHeader is the HTTP header carrying the W3C trace context.
+	TraceparentHeader = "traceparent"
+)
+
+// WithTraceKeys adds the trace and span ids stored in the context with ContextWithTrace or ContextWithTraceparent to
+// every record, under traceKey and spanKey, e.g. TraceIDKey and SpanIDKey.
+func WithTraceKeys(traceKey, spanKey string) Option {
+	return WithContextExtractor(func(ctx context.Context) []slog.Attr {
+		ids, ok := ctx.Value(traceContextKey{}).(traceIDs)
+		if !ok {
+			return nil
+		}
+
+		return []slog.Attr{slog.String(traceKey, ids.trace), slog.String(spanKey, ids.span)}
+	})
+}
+
+// ContextWithTrace returns a copy of ctx carrying the trace and span ids, for WithTraceKeys.
+func ContextWithTrace(ctx context.Context, traceID, spanID string) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, traceIDs{trace: traceID, span: spanID})
+}
+
+// ContextWithTraceparent returns a copy of ctx carrying the trace and span ids of the W3C traceparent header, for
+// HTTP servers without OpenTelemetry, e.g.
+//
+//	ctx := calldepth.ContextWithTraceparent(r.Context(), r.Header.Get(calldepth.TraceparentHeader))
+//
+// A malformed header is ignored and ctx returned as is.
+func ContextWithTraceparent(ctx context.Context, header string) context.Context {
+	traceID, spanID, ok := ParseTraceparent(header)
+	if !ok {
+		return ctx
+	}
+
+	return ContextWithTrace(ctx, traceID, spanID)
+}
+
+// ParseTraceparent returns the trace id and the parent span id of a W3C traceparent header, e.g.
+// `00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01`, and false if the header is malformed. Headers of a
+// version above 00 may carry more fields, which are ignored.
+func ParseTraceparent(header string) (string, string, bool) {
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 {
+		return "", "", false
+	}
+
+	version, traceID, spanID, flags := fields[0], fields[1], fields[2], fields[3]
+
+	switch {
+	case !isHex(version, 2) || version == "ff":
+		return "", "", false
+	case version == "00" && len(fields) != 4:
+		return "", "", false
+	case !isHex(traceID, 32) || strings.Trim(traceID, "0") == "":
+		return "", "", false
+	case !isHex(spanID, 16) || strings.Trim(spanID, "0") == "":
+		return "", "", false
+	case !isHex(flags, 2):
+		return "", "", false
+	}
+
+	return traceID, spanID, true
+}
+
+// isHex reports whether s is made of size lower case hexadecimal digits.
+func isHex(s string, size int) bool {
+	if len(s) != size {
+		return false
+	}
+
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
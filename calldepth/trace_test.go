@@ -0,0 +1,83 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package calldepth_test
+
+import (
+	"context"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+	}{
+		{"valid", "00-" + traceID + "-" + spanID + "-01", true},
+		{"future version", "01-" + traceID + "-" + spanID + "-01-extra", true},
+		{"extra field", "00-" + traceID + "-" + spanID + "-01-extra", false},
+		{"invalid version", "ff-" + traceID + "-" + spanID + "-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", false},
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false},
+		{"short span id", "00-" + traceID + "-00f067aa-01", false},
+		{"missing flags", "00-" + traceID + "-" + spanID, false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, span, ok := calldepth.ParseTraceparent(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("ParseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+			}
+
+			if ok && (trace != traceID || span != spanID) {
+				t.Errorf("ParseTraceparent(%q) = %q, %q, want %q, %q", tt.header, trace, span, traceID, spanID)
+			}
+		})
+	}
+}
+
+func TestWithTraceKeys(t *testing.T) {
+	a, buf := newJSON(calldepth.WithTraceKeys(calldepth.TraceIDKey, calldepth.SpanIDKey))
+
+	a.InfoContext(calldepth.ContextWithTraceparent(context.Background(), "00-"+traceID+"-"+spanID+"-01"), "m")
+	a.InfoContext(calldepth.ContextWithTraceparent(context.Background(), "malformed"), "m")
+
+	out := records(t, buf)
+	if len(out) != 2 {
+		t.Fatalf("got %d records, want 2", len(out))
+	}
+
+	if out[0][calldepth.TraceIDKey] != traceID || out[0][calldepth.SpanIDKey] != spanID {
+		t.Errorf("record = %v, want the ids of the header", out[0])
+	}
+
+	if _, ok := out[1][calldepth.TraceIDKey]; ok {
+		t.Errorf("record = %v, want no ids for a malformed header", out[1])
+	}
+}
@@ -1,3 +1,21 @@
 module go.breu.io/slog-utils
 
 go 1.21.0
+
+require (
+	github.com/prometheus/client_golang v1.19.1
+	go.opentelemetry.io/otel/trace v1.24.0
+	golang.org/x/sys v0.17.0
+	golang.org/x/text v0.14.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	go.opentelemetry.io/otel v1.24.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+// Package buffer provides the pooled byte buffers the bundled handlers format records into.
+package buffer
+
+import (
+	"sync"
+)
+
+type (
+	// Buffer is a byte buffer taken from the pool with New and returned to it with Free.
+	Buffer []byte
+)
+
+const (
+	// initialSize is the capacity of the buffers allocated by the pool.
+	initialSize = 1 << 10
+
+	// maxSize is the capacity above which buffers are not returned to the pool, so that a few large records do not
+	// keep their memory retained.
+	maxSize = 16 << 10
+)
+
+var (
+	pool = sync.Pool{
+		New: func() any {
+			b := make(Buffer, 0, initialSize)
+
+			return &b
+		},
+	}
+)
+
+// New returns an empty buffer from the pool.
+func New() *Buffer {
+	return pool.Get().(*Buffer)
+}
+
+// Free returns the buffer to the pool. The buffer must not be used afterwards.
+func (b *Buffer) Free() {
+	if cap(*b) > maxSize {
+		return
+	}
+
+	*b = (*b)[:0]
+	pool.Put(b)
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	*b = append(*b, p...)
+
+	return len(p), nil
+}
+
+func (b *Buffer) WriteString(s string) (int, error) {
+	*b = append(*b, s...)
+
+	return len(s), nil
+}
+
+func (b *Buffer) WriteByte(c byte) error {
+	*b = append(*b, c)
+
+	return nil
+}
+
+// String returns the content of the buffer.
+func (b *Buffer) String() string {
+	return string(*b)
+}
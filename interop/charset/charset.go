@@ -0,0 +1,72 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package charset provides a writer transcoding log output from UTF-8 to another character encoding, for sinks
+// expecting e.g. ISO-8859-1 or Shift JIS.
+package charset
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+
+	"golang.org/x/text/encoding"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// Writer transcodes what is written to it from UTF-8 to an encoding before writing it to the underlying writer.
+	// Characters that the encoding cannot represent are replaced with its replacement character, e.g. the SUB control
+	// character for the code pages of charmap. It is safe for concurrent use.
+	Writer struct {
+		mu  sync.Mutex
+		w   io.Writer
+		enc *encoding.Encoder
+	}
+)
+
+// WithOutputEncoding returns an option logging with a slog.TextHandler writing to w in enc, e.g.
+// charmap.ISO8859_1 of golang.org/x/text/encoding/charmap.
+func WithOutputEncoding(w io.Writer, enc encoding.Encoding, opts *slog.HandlerOptions) calldepth.Option {
+	return calldepth.WithLogger(slog.New(slog.NewTextHandler(NewWriter(w, enc), opts)))
+}
+
+// NewWriter returns a Writer writing to w in enc, for handlers other than slog.TextHandler. Each write must hold
+// whole characters, as the writes of slog's handlers do.
+func NewWriter(w io.Writer, enc encoding.Encoding) *Writer {
+	return &Writer{w: w, enc: encoding.ReplaceUnsupported(enc.NewEncoder())}
+}
+
+// Write transcodes p and writes it to the underlying writer. It returns the length of p once all of it was written.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	out, err := w.enc.Bytes(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package charset_test
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/interop/charset"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []byte
+	}{
+		{"ascii", "user=alice", []byte("user=alice")},
+		{"latin", "café", []byte{'c', 'a', 'f', 0xe9}},
+		{"unsupported", "日本", []byte{0x1a, 0x1a}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			n, err := charset.NewWriter(&buf, charmap.ISO8859_1).Write([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != len(tt.in) {
+				t.Errorf("wrote %d bytes, want the %d bytes of the input", n, len(tt.in))
+			}
+
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("output = %q, want %q", buf.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWithOutputEncoding(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := calldepth.New(charset.WithOutputEncoding(&buf, charmap.ISO8859_1, nil))
+	a.Info("déjà vu", "user", "zoë")
+
+	if want := []byte("msg=\"d\xe9j\xe0 vu\" user=zo\xeb\n"); !bytes.HasSuffix(buf.Bytes(), want) {
+		t.Errorf("output = %q, want it ending with %q", buf.Bytes(), want)
+	}
+}
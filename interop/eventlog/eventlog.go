@@ -0,0 +1,158 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build windows
+
+// Package eventlog provides a slog handler writing to the Windows event log.
+package eventlog
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/internal/buffer"
+)
+
+type (
+	// Writer writes messages as events of the event log types. *eventlog.Log implements it.
+	Writer interface {
+		Info(eid uint32, msg string) error
+		Warning(eid uint32, msg string) error
+		Error(eid uint32, msg string) error
+	}
+
+	// Handler is a slog.Handler writing records to the event log, as events of the type mapped from their level. The
+	// message is the record's message followed by its attributes in the format of slog.TextHandler; the time and the
+	// level are left to the event log.
+	Handler struct {
+		text slog.Handler
+		out  *output
+	}
+
+	// output is the writer and the buffer shared by the handlers derived from a Handler.
+	output struct {
+		mu  sync.Mutex
+		w   Writer
+		buf *buffer.Buffer // buf is the buffer of the record being handled, taken from the pool.
+	}
+
+	// textBuffer is the writer the text handler formats records into.
+	textBuffer output
+)
+
+const (
+	// EventID is the event identifier of the events written by the Handler.
+	EventID uint32 = 1
+)
+
+// WithEventLog returns an option logging the records to the event log under the given source, which must have been
+// registered, e.g. with eventlog.InstallAsEventCreate.
+func WithEventLog(source string) (calldepth.Option, error) {
+	w, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return calldepth.WithLogger(slog.New(NewHandler(w, nil))), nil
+}
+
+// NewHandler returns a Handler writing to w. Only the level and the AddSource options are used.
+func NewHandler(w Writer, opts *slog.HandlerOptions) *Handler {
+	out := &output{w: w}
+
+	text := &slog.HandlerOptions{ReplaceAttr: omitTimeAndLevel}
+	if opts != nil {
+		text.Level = opts.Level
+		text.AddSource = opts.AddSource
+	}
+
+	return &Handler{text: slog.NewTextHandler((*textBuffer)(out), text), out: out}
+}
+
+// EventType returns the event log type of the level: information below warn, warning below error and error above.
+func EventType(level slog.Level) uint16 {
+	switch {
+	case level < slog.LevelWarn:
+		return eventlog.Info
+	case level < slog.LevelError:
+		return eventlog.Warning
+	default:
+		return eventlog.Error
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.text.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+
+	h.out.buf = buffer.New()
+	defer h.out.release()
+
+	if err := h.text.Handle(ctx, record); err != nil {
+		return err
+	}
+
+	msg := strings.TrimSuffix(h.out.buf.String(), "\n")
+
+	switch EventType(record.Level) {
+	case eventlog.Info:
+		return h.out.w.Info(EventID, msg)
+	case eventlog.Warning:
+		return h.out.w.Warning(EventID, msg)
+	default:
+		return h.out.w.Error(EventID, msg)
+	}
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{text: h.text.WithAttrs(attrs), out: h.out}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{text: h.text.WithGroup(name), out: h.out}
+}
+
+// release returns the buffer of the record handled to the pool.
+func (o *output) release() {
+	o.buf.Free()
+	o.buf = nil
+}
+
+// Write appends to the buffer of the output. It is called by the text handler while the output is locked.
+func (b *textBuffer) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// omitTimeAndLevel removes the time and the level of the records, which the event log records on its own.
+func omitTimeAndLevel(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) == 0 && (attr.Key == slog.TimeKey || attr.Key == slog.LevelKey) {
+		return slog.Attr{}
+	}
+
+	return attr
+}
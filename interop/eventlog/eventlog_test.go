@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build windows
+
+package eventlog_test
+
+import (
+	"log/slog"
+	"testing"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+
+	"go.breu.io/slog-utils/calldepth"
+	slogeventlog "go.breu.io/slog-utils/interop/eventlog"
+)
+
+type (
+	// fakeWriter records the events written, prefixed with their type.
+	fakeWriter struct {
+		events []string
+	}
+)
+
+func (w *fakeWriter) write(typ string, eid uint32, msg string) error {
+	if eid != slogeventlog.EventID {
+		return nil
+	}
+
+	w.events = append(w.events, typ+" "+msg)
+
+	return nil
+}
+
+func (w *fakeWriter) Info(eid uint32, msg string) error { return w.write("info", eid, msg) }
+
+func (w *fakeWriter) Warning(eid uint32, msg string) error { return w.write("warning", eid, msg) }
+
+func (w *fakeWriter) Error(eid uint32, msg string) error { return w.write("error", eid, msg) }
+
+func TestEventType(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  uint16
+	}{
+		{slog.LevelDebug, eventlog.Info},
+		{slog.LevelInfo, eventlog.Info},
+		{slog.LevelWarn, eventlog.Warning},
+		{slog.LevelError, eventlog.Error},
+		{slog.LevelError + 4, eventlog.Error},
+	}
+
+	for _, tt := range tests {
+		if got := slogeventlog.EventType(tt.level); got != tt.want {
+			t.Errorf("EventType(%v) = %d, want %d", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	w := &fakeWriter{}
+	a := calldepth.New(calldepth.WithLogger(slog.New(slogeventlog.NewHandler(w, nil))))
+
+	a.Info("started", "port", 8080)
+	a.With("service", "api").Warn("slow")
+	a.Error("failed")
+
+	want := []string{"info msg=started port=8080", "warning msg=slow service=api", "error msg=failed"}
+	if len(w.events) != len(want) {
+		t.Fatalf("events = %q, want %q", w.events, want)
+	}
+
+	for i := range want {
+		if w.events[i] != want[i] {
+			t.Errorf("event = %q, want %q", w.events[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package httplog provides net/http middlewares logging with the adapter of the request.
+package httplog
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// recorder is a http.ResponseWriter recording whether the header was written. It implements http.Flusher and
+	// http.Hijacker for the handlers behind Recover, e.g. streaming events or upgrading to websockets, passing the
+	// calls to the underlying writer; Hijack returns http.ErrNotSupported if the underlying writer cannot hijack.
+	recorder struct {
+		http.ResponseWriter
+		written bool
+	}
+)
+
+const (
+	// PanicMessage is the message of the records logged by Recover.
+	PanicMessage = "http: panic serving request"
+
+	// MethodKey is the key of the attribute holding the method of the request.
+	MethodKey = "method"
+
+	// PathKey is the key of the attribute holding the path of the request.
+	PathKey = "path"
+)
+
+// Recover returns a handler calling next and recovering from its panics: the panic is logged at error with the stack
+// trace, by the adapter stored in the context of the request with calldepth.IntoContext or the default one, and the
+// client is sent a 500 response if nothing was written yet. A panic with http.ErrAbortHandler is let through, for
+// the server to abort the response.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &recorder{ResponseWriter: w}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			if recovered == http.ErrAbortHandler { //nolint:errorlint // the value is compared, as by net/http.
+				panic(recovered)
+			}
+
+			err, ok := recovered.(error)
+			if !ok {
+				err = fmt.Errorf("panic: %v", recovered)
+			}
+
+			calldepth.FromContext(r.Context()).ErrorContext(r.Context(), PanicMessage,
+				calldepth.ErrorKey, err,
+				calldepth.StackTraceKey, string(debug.Stack()),
+				MethodKey, r.Method,
+				PathKey, r.URL.Path,
+			)
+
+			if !rec.written {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+func (r *recorder) WriteHeader(code int) {
+	r.written = true
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.written = true
+
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush sends the buffered data to the client, if the underlying writer supports it.
+func (r *recorder) Flush() {
+	r.written = true
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Hijack lets the caller take over the connection, after which no 500 response is sent on panic.
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.written = true
+	}
+
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package httplog_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/interop/httplog"
+)
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := calldepth.New(calldepth.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+
+	handler := httplog.Recover(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	handler.ServeHTTP(w, r.WithContext(calldepth.IntoContext(r.Context(), a)))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+
+	if out := buf.String(); !strings.Contains(out, httplog.PanicMessage) || !strings.Contains(out, "path=/orders") {
+		t.Errorf("panic not logged: %s", out)
+	}
+}
+
+func TestRecoverWithoutContextAdapter(t *testing.T) {
+	handler := httplog.Recover(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+}
+
+func TestRecoverAfterWrite(t *testing.T) {
+	handler := httplog.Recover(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want the status written before the panic", w.Code)
+	}
+}
+
+func TestRecoverFlush(t *testing.T) {
+	handler := httplog.Recover(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("writer behind Recover is not a http.Flusher")
+		}
+
+		_, _ = w.Write([]byte("data: 1\n\n"))
+		flusher.Flush()
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if !w.Flushed {
+		t.Error("flush did not reach the underlying writer")
+	}
+}
+
+func TestRecoverHijack(t *testing.T) {
+	server := httptest.NewServer(httplog.Recover(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("writer behind Recover is not a http.Hijacker")
+
+			return
+		}
+
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			t.Error(err)
+
+			return
+		}
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: close\r\n\r\n")
+		_ = rw.Flush()
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status = %d, want the response written on the hijacked connection", resp.StatusCode)
+	}
+}
+
+func TestRecoverHijackNotSupported(t *testing.T) {
+	var err error
+
+	handler := httplog.Recover(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _, err = w.(http.Hijacker).Hijack()
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("error = %v, want http.ErrNotSupported", err)
+	}
+}
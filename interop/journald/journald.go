@@ -0,0 +1,280 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package journald provides a slog handler writing structured records to the systemd journal.
+package journald
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/internal/buffer"
+)
+
+type (
+	// Handler is a slog.Handler writing records to the journal as structured fields: the message as MESSAGE, the
+	// level as PRIORITY, the source as CODE_FILE, CODE_LINE and CODE_FUNC, and the attributes with their keys in upper
+	// case, joined to their groups by underscores.
+	Handler struct {
+		opts   slog.HandlerOptions
+		out    *output
+		fields []field // fields are the attributes added with WithAttrs.
+		prefix string  // prefix is the field name prefix of the groups opened with WithGroup.
+	}
+
+	// output is the writer shared by the handlers derived from a Handler.
+	output struct {
+		mu     sync.Mutex
+		w      io.Writer
+		native bool // native writes the journal protocol, one record per write, instead of lines with a priority prefix.
+	}
+
+	// field is a journal field.
+	field struct {
+		name  string
+		value string
+	}
+)
+
+const (
+	// SocketPath is the path of the socket of the journal.
+	SocketPath = "/run/systemd/journal/socket"
+)
+
+// WithJournald returns an option logging the records to the journal through its socket or, if it cannot be reached,
+// to stderr with the priority prefix understood by journald for the output of services.
+func WithJournald() calldepth.Option {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: SocketPath, Net: "unixgram"})
+	if err != nil {
+		return calldepth.WithLogger(slog.New(NewStreamHandler(os.Stderr, nil)))
+	}
+
+	return calldepth.WithLogger(slog.New(NewHandler(conn, nil)))
+}
+
+// NewHandler returns a Handler writing each record to w, usually a connection to SocketPath, with the native journal
+// protocol. Only the level and the AddSource options are used.
+func NewHandler(w io.Writer, opts *slog.HandlerOptions) *Handler {
+	return newHandler(w, opts, true)
+}
+
+// NewStreamHandler returns a Handler writing each record to w as a line prefixed with its priority, e.g. `<3>`,
+// followed by the message and the fields. Only the level and the AddSource options are used.
+func NewStreamHandler(w io.Writer, opts *slog.HandlerOptions) *Handler {
+	return newHandler(w, opts, false)
+}
+
+// Priority returns the journal priority of the level, from 7 for debug to 2 for levels above error.
+func Priority(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return 7
+	case level < slog.LevelWarn:
+		return 6
+	case level < slog.LevelError:
+		return 4
+	case level == slog.LevelError:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// FieldName returns the journal field name for the key: in upper case, with the characters other than letters and
+// digits replaced by underscores, and without leading underscores, which are reserved for trusted fields.
+func FieldName(key string) string {
+	name := strings.TrimLeft(strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+
+		return '_'
+	}, key), "_")
+
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+
+	return name
+}
+
+func newHandler(w io.Writer, opts *slog.HandlerOptions, native bool) *Handler {
+	h := &Handler{out: &output{w: w, native: native}}
+	if opts != nil {
+		h.opts = *opts
+	}
+
+	return h
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	minimum := slog.LevelInfo
+	if h.opts.Level != nil {
+		minimum = h.opts.Level.Level()
+	}
+
+	return level >= minimum
+}
+
+func (h *Handler) Handle(_ context.Context, record slog.Record) error {
+	fields := make([]field, 0, 5+len(h.fields)+record.NumAttrs())
+	fields = append(fields,
+		field{name: "MESSAGE", value: record.Message},
+		field{name: "PRIORITY", value: strconv.Itoa(Priority(record.Level))},
+	)
+
+	if h.opts.AddSource && record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		fields = append(fields,
+			field{name: "CODE_FILE", value: frame.File},
+			field{name: "CODE_LINE", value: strconv.Itoa(frame.Line)},
+			field{name: "CODE_FUNC", value: frame.Function},
+		)
+	}
+
+	fields = append(fields, h.fields...)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendFields(fields, h.prefix, attr)
+
+		return true
+	})
+
+	buf := buffer.New()
+	defer buf.Free()
+
+	if h.out.native {
+		encodeNative(buf, fields)
+	} else {
+		encodeStream(buf, fields)
+	}
+
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+
+	_, err := h.out.w.Write(*buf)
+
+	return err
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.fields = h.fields[:len(h.fields):len(h.fields)]
+
+	for _, attr := range attrs {
+		c.fields = appendFields(c.fields, h.prefix, attr)
+	}
+
+	return &c
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	c := *h
+	c.prefix = h.prefix + name + "_"
+
+	return &c
+}
+
+// appendFields appends the fields of the attribute, flattening groups.
+func appendFields(fields []field, prefix string, attr slog.Attr) []field {
+	value := attr.Value.Resolve()
+
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "_"
+		}
+
+		for _, member := range value.Group() {
+			fields = appendFields(fields, prefix, member)
+		}
+
+		return fields
+	}
+
+	if attr.Key == "" {
+		return fields
+	}
+
+	return append(fields, field{name: FieldName(prefix + attr.Key), value: formatValue(value)})
+}
+
+// formatValue returns the value as a string.
+func formatValue(value slog.Value) string {
+	if value.Kind() == slog.KindTime {
+		return value.Time().Format(time.RFC3339Nano)
+	}
+
+	return value.String()
+}
+
+// encodeNative writes the fields in the native journal protocol, using the binary form for multi-line values.
+func encodeNative(buf *buffer.Buffer, fields []field) {
+	for _, f := range fields {
+		buf.WriteString(f.name)
+
+		if !strings.Contains(f.value, "\n") {
+			buf.WriteByte('=')
+			buf.WriteString(f.value)
+			buf.WriteByte('\n')
+
+			continue
+		}
+
+		var size [8]byte
+
+		binary.LittleEndian.PutUint64(size[:], uint64(len(f.value)))
+
+		buf.WriteByte('\n')
+		buf.Write(size[:])
+		buf.WriteString(f.value)
+		buf.WriteByte('\n')
+	}
+}
+
+// encodeStream writes the fields as a line prefixed with the priority, followed by the message and the other fields.
+func encodeStream(buf *buffer.Buffer, fields []field) {
+	buf.WriteByte('<')
+	buf.WriteString(fields[1].value)
+	buf.WriteByte('>')
+	buf.WriteString(strings.ReplaceAll(fields[0].value, "\n", " "))
+
+	for _, f := range fields[2:] {
+		buf.WriteByte(' ')
+		buf.WriteString(f.name)
+		buf.WriteByte('=')
+		buf.WriteString(strconv.Quote(f.value))
+	}
+
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package journald_test
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/interop/journald"
+)
+
+func TestPriority(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  int
+	}{
+		{slog.LevelDebug, 7},
+		{slog.LevelInfo, 6},
+		{slog.LevelWarn, 4},
+		{slog.LevelError, 3},
+		{slog.LevelError + 4, 2},
+	}
+
+	for _, tt := range tests {
+		if got := journald.Priority(tt.level); got != tt.want {
+			t.Errorf("Priority(%v) = %d, want %d", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	tests := map[string]string{
+		"user":       "USER",
+		"http.path":  "HTTP_PATH",
+		"_private":   "PRIVATE",
+		"2fa":        "X2FA",
+		"résumé":     "R_SUM_",
+		"request-id": "REQUEST_ID",
+	}
+
+	for key, want := range tests {
+		if got := journald.FieldName(key); got != want {
+			t.Errorf("FieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := calldepth.New(calldepth.WithLogger(slog.New(journald.NewHandler(&buf, nil))))
+
+	a.With("service", "api").WithGroup("req").Warn("slow\nrequest", "path", "/users")
+
+	want := "MESSAGE\n\x0c\x00\x00\x00\x00\x00\x00\x00slow\nrequest\nPRIORITY=4\nSERVICE=api\nREQ_PATH=/users\n"
+	if got := buf.String(); got != want {
+		t.Errorf("entry = %q, want %q", got, want)
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := calldepth.New(calldepth.WithLogger(slog.New(journald.NewStreamHandler(&buf, nil))))
+
+	a.Error("failed\nagain", "user", "alice")
+
+	if got, want := buf.String(), "<3>failed again USER=\"alice\"\n"; got != want {
+		t.Errorf("line = %q, want %q", got, want)
+	}
+}
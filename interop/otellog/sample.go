@@ -0,0 +1,48 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package otellog aligns calldepth adapters with OpenTelemetry traces.
+package otellog
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+// WithTraceBasedSampling logs the records of the spans sampled by the tracer and drops those of the spans it did not
+// sample, so that the logs kept match the traces kept. Records at error level and above are always logged, as are the
+// records logged outside of a span. It sets the sampler of the adapter.
+func WithTraceBasedSampling() calldepth.Option {
+	return calldepth.WithSampler(TraceSampler)
+}
+
+// TraceSampler is the calldepth.Sampler of WithTraceBasedSampling, to combine with other samplers.
+func TraceSampler(ctx context.Context, level slog.Level) bool {
+	if level >= slog.LevelError {
+		return true
+	}
+
+	sc := trace.SpanContextFromContext(ctx)
+
+	return !sc.IsValid() || sc.IsSampled()
+}
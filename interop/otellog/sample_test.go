@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package otellog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/interop/otellog"
+)
+
+// spanContext returns a context carrying a span with the trace flags.
+func spanContext(flags trace.TraceFlags) context.Context {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: flags,
+	})
+
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func TestWithTraceBasedSampling(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := calldepth.New(calldepth.WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))), otellog.WithTraceBasedSampling())
+
+	a.InfoContext(spanContext(trace.FlagsSampled), "sampled")
+	a.InfoContext(spanContext(0), "unsampled")
+	a.ErrorContext(spanContext(0), "unsampled error")
+	a.InfoContext(context.Background(), "no span")
+
+	var got []string
+
+	for dec := json.NewDecoder(&buf); dec.More(); {
+		record := map[string]any{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, _ := record["msg"].(string)
+		got = append(got, msg)
+	}
+
+	if want := []string{"sampled", "unsampled error", "no span"}; !slices.Equal(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package promlog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+type (
+	// Counter is a prometheus.Collector counting the records logged, labeled by level.
+	Counter struct {
+		records *prometheus.CounterVec
+	}
+)
+
+const (
+	// CounterName is the name of the metric of a Counter.
+	CounterName = "log_records_total"
+
+	// LevelLabel is the label holding the level of the records, e.g. "INFO".
+	LevelLabel = "level"
+)
+
+// NewCounter returns a Counter, to register on a registry before passing it to WithCounter.
+func NewCounter() *Counter {
+	return &Counter{
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: CounterName,
+			Help: "Number of log records logged, by level.",
+		}, []string{LevelLabel}),
+	}
+}
+
+// WithPrometheusCounter registers a new Counter on reg and returns an option counting the records logged with it.
+func WithPrometheusCounter(reg prometheus.Registerer) (calldepth.Option, error) {
+	counter := NewCounter()
+	if err := reg.Register(counter); err != nil {
+		return nil, err
+	}
+
+	return WithCounter(counter), nil
+}
+
+// WithCounter counts the records emitted with the counter, those passed to the handler. The records dropped by the
+// sampler or by filters such as WithDebounce are not counted.
+func WithCounter(counter *Counter) calldepth.Option {
+	return calldepth.WithEmitObserver(func(_ context.Context, record slog.Record) {
+		counter.records.WithLabelValues(record.Level.String()).Inc()
+	})
+}
+
+func (c *Counter) Describe(ch chan<- *prometheus.Desc) {
+	c.records.Describe(ch)
+}
+
+func (c *Counter) Collect(ch chan<- prometheus.Metric) {
+	c.records.Collect(ch)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package promlog_test
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/interop/promlog"
+)
+
+func TestWithCounter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := promlog.NewCounter()
+	reg.MustRegister(counter)
+
+	a := calldepth.New(calldepth.WithLogger(slog.New(slog.NewJSONHandler(io.Discard, nil))), promlog.WithCounter(counter))
+
+	a.Info("m")
+	a.Info("m")
+	a.Error("m")
+	a.Debug("m")
+
+	want := `
+# HELP log_records_total Number of log records logged, by level.
+# TYPE log_records_total counter
+log_records_total{level="ERROR"} 1
+log_records_total{level="INFO"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), promlog.CounterName); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithCounterFiltered(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := promlog.NewCounter()
+	reg.MustRegister(counter)
+
+	a := calldepth.New(
+		calldepth.WithLogger(slog.New(slog.NewJSONHandler(io.Discard, nil))),
+		promlog.WithCounter(counter),
+		calldepth.WithDebounce(time.Hour, func(r slog.Record) string { return r.Message }),
+	)
+
+	a.Info("retrying")
+	a.Info("retrying")
+	a.Info("retrying")
+
+	want := `
+# HELP log_records_total Number of log records logged, by level.
+# TYPE log_records_total counter
+log_records_total{level="INFO"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), promlog.CounterName); err != nil {
+		t.Errorf("records debounced were counted: %v", err)
+	}
+}
+
+func TestWithPrometheusCounter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	option, err := promlog.WithPrometheusCounter(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := calldepth.New(calldepth.WithLogger(slog.New(slog.NewJSONHandler(io.Discard, nil))), option)
+	a.Warn("m")
+
+	if got := testutil.CollectAndCount(reg, promlog.CounterName); got != 1 {
+		t.Errorf("collected %d series, want 1", got)
+	}
+
+	if _, err := promlog.WithPrometheusCounter(reg); err == nil {
+		t.Error("registering a second counter on the registry succeeded, want an error")
+	}
+}
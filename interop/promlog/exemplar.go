@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package promlog links calldepth adapters with Prometheus metrics.
+package promlog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+
+	"go.breu.io/slog-utils/calldepth"
+)
+
+const (
+	// TraceIDKey is the key of the trace id, both as an attribute of the records and as an exemplar label.
+	TraceIDKey = calldepth.TraceIDKey
+
+	// SpanIDKey is the key of the span id attribute of the records.
+	SpanIDKey = calldepth.SpanIDKey
+)
+
+// WithTraceExemplar adds the trace and span ids of the span in the context, if any, to every record, so that the logs
+// can be joined with the metrics whose exemplars are labeled with ExemplarLabels.
+func WithTraceExemplar() calldepth.Option {
+	return calldepth.WithContextExtractor(func(ctx context.Context) []slog.Attr {
+		sc := trace.SpanContextFromContext(ctx)
+		if !sc.IsValid() {
+			return nil
+		}
+
+		return []slog.Attr{
+			slog.String(TraceIDKey, sc.TraceID().String()),
+			slog.String(SpanIDKey, sc.SpanID().String()),
+		}
+	})
+}
+
+// ExemplarLabels returns the exemplar labels for the span in the context, or nil if there is none, e.g.
+//
+//	histogram.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed, promlog.ExemplarLabels(ctx))
+func ExemplarLabels(ctx context.Context) prometheus.Labels {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+
+	return prometheus.Labels{TraceIDKey: sc.TraceID().String()}
+}
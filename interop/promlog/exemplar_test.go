@@ -0,0 +1,74 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+package promlog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/interop/promlog"
+)
+
+// spanContext returns a context carrying a sampled span.
+func spanContext() (context.Context, trace.SpanContext) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	return trace.ContextWithSpanContext(context.Background(), sc), sc
+}
+
+func TestExemplarLabels(t *testing.T) {
+	ctx, sc := spanContext()
+
+	labels := promlog.ExemplarLabels(ctx)
+	if len(labels) != 1 || labels[promlog.TraceIDKey] != sc.TraceID().String() {
+		t.Errorf("labels = %v, want the trace id", labels)
+	}
+
+	if labels := promlog.ExemplarLabels(context.Background()); labels != nil {
+		t.Errorf("labels = %v without a span, want nil", labels)
+	}
+}
+
+func TestWithTraceExemplar(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := calldepth.New(calldepth.WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))), promlog.WithTraceExemplar())
+	ctx, sc := spanContext()
+
+	a.InfoContext(ctx, "m")
+
+	record := map[string]any{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	if record[promlog.TraceIDKey] != sc.TraceID().String() || record[promlog.SpanIDKey] != sc.SpanID().String() {
+		t.Errorf("record = %v, want the trace and span ids of the exemplar", record)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build !windows && !plan9
+
+// Package syslog provides a slog handler writing to the local or a remote syslog daemon.
+package syslog
+
+import (
+	"context"
+	"log/slog"
+	stdsyslog "log/syslog"
+	"strings"
+	"sync"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/internal/buffer"
+)
+
+type (
+	// Writer writes messages at the syslog priorities. *log/syslog.Writer implements it, reconnecting to the daemon
+	// when a write fails.
+	Writer interface {
+		Debug(m string) error
+		Info(m string) error
+		Warning(m string) error
+		Err(m string) error
+		Crit(m string) error
+	}
+
+	// Handler is a slog.Handler writing records to syslog, at the priority mapped from their level. The message is
+	// the record's message followed by its attributes in the format of slog.TextHandler; the time and the level are
+	// left to syslog.
+	Handler struct {
+		text slog.Handler
+		out  *output
+	}
+
+	// output is the writer and the buffer shared by the handlers derived from a Handler.
+	output struct {
+		mu  sync.Mutex
+		w   Writer
+		buf *buffer.Buffer // buf is the buffer of the record being handled, taken from the pool.
+	}
+
+	// textBuffer is the writer the text handler formats records into.
+	textBuffer output
+)
+
+// WithSyslog returns an option logging the records to the syslog daemon at addr over network, e.g. "udp", with the
+// given tag. If network is empty, the local daemon is used.
+func WithSyslog(network, addr, tag string) (calldepth.Option, error) {
+	w, err := stdsyslog.Dial(network, addr, stdsyslog.LOG_USER|stdsyslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return calldepth.WithLogger(slog.New(NewHandler(w, nil))), nil
+}
+
+// NewHandler returns a Handler writing to w. Only the level and the AddSource options are used.
+func NewHandler(w Writer, opts *slog.HandlerOptions) *Handler {
+	out := &output{w: w}
+
+	text := &slog.HandlerOptions{ReplaceAttr: omitTimeAndLevel}
+	if opts != nil {
+		text.Level = opts.Level
+		text.AddSource = opts.AddSource
+	}
+
+	return &Handler{text: slog.NewTextHandler((*textBuffer)(out), text), out: out}
+}
+
+// Priority returns the syslog priority of the level.
+func Priority(level slog.Level) stdsyslog.Priority {
+	switch {
+	case level < slog.LevelInfo:
+		return stdsyslog.LOG_DEBUG
+	case level < slog.LevelWarn:
+		return stdsyslog.LOG_INFO
+	case level < slog.LevelError:
+		return stdsyslog.LOG_WARNING
+	case level == slog.LevelError:
+		return stdsyslog.LOG_ERR
+	default:
+		return stdsyslog.LOG_CRIT
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.text.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+
+	h.out.buf = buffer.New()
+	defer h.out.release()
+
+	if err := h.text.Handle(ctx, record); err != nil {
+		return err
+	}
+
+	msg := strings.TrimSuffix(h.out.buf.String(), "\n")
+
+	switch Priority(record.Level) { //nolint:exhaustive // levels map to these priorities only.
+	case stdsyslog.LOG_DEBUG:
+		return h.out.w.Debug(msg)
+	case stdsyslog.LOG_INFO:
+		return h.out.w.Info(msg)
+	case stdsyslog.LOG_WARNING:
+		return h.out.w.Warning(msg)
+	case stdsyslog.LOG_ERR:
+		return h.out.w.Err(msg)
+	default:
+		return h.out.w.Crit(msg)
+	}
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{text: h.text.WithAttrs(attrs), out: h.out}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{text: h.text.WithGroup(name), out: h.out}
+}
+
+// release returns the buffer of the record handled to the pool.
+func (o *output) release() {
+	o.buf.Free()
+	o.buf = nil
+}
+
+// Write appends to the buffer of the output. It is called by the text handler while the output is locked.
+func (b *textBuffer) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// omitTimeAndLevel removes the time and the level of the records, which syslog records on its own.
+func omitTimeAndLevel(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) == 0 && (attr.Key == slog.TimeKey || attr.Key == slog.LevelKey) {
+		return slog.Attr{}
+	}
+
+	return attr
+}
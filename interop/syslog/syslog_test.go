@@ -0,0 +1,127 @@
+// Copyright (c) 2023 Breu Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build !windows && !plan9
+
+package syslog_test
+
+import (
+	"log/slog"
+	stdsyslog "log/syslog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.breu.io/slog-utils/calldepth"
+	"go.breu.io/slog-utils/interop/syslog"
+)
+
+type (
+	// fakeWriter records the messages written, prefixed with their priority.
+	fakeWriter struct {
+		lines []string
+	}
+)
+
+func (w *fakeWriter) write(priority, m string) error {
+	w.lines = append(w.lines, priority+" "+m)
+
+	return nil
+}
+
+func (w *fakeWriter) Debug(m string) error { return w.write("debug", m) }
+
+func (w *fakeWriter) Info(m string) error { return w.write("info", m) }
+
+func (w *fakeWriter) Warning(m string) error { return w.write("warning", m) }
+
+func (w *fakeWriter) Err(m string) error { return w.write("err", m) }
+
+func (w *fakeWriter) Crit(m string) error { return w.write("crit", m) }
+
+func TestPriority(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  stdsyslog.Priority
+	}{
+		{slog.LevelDebug, stdsyslog.LOG_DEBUG},
+		{slog.LevelInfo, stdsyslog.LOG_INFO},
+		{slog.LevelInfo + 2, stdsyslog.LOG_INFO},
+		{slog.LevelWarn, stdsyslog.LOG_WARNING},
+		{slog.LevelError, stdsyslog.LOG_ERR},
+		{slog.LevelError + 4, stdsyslog.LOG_CRIT},
+	}
+
+	for _, tt := range tests {
+		if got := syslog.Priority(tt.level); got != tt.want {
+			t.Errorf("Priority(%v) = %v, want %v", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	w := &fakeWriter{}
+	a := calldepth.New(calldepth.WithLogger(slog.New(syslog.NewHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+
+	a.With("service", "api").Warn("disk almost full", "free", "1%")
+	a.Error("failed")
+
+	want := []string{`warning msg="disk almost full" service=api free=1%`, "err msg=failed"}
+	if len(w.lines) != len(want) {
+		t.Fatalf("lines = %q, want %q", w.lines, want)
+	}
+
+	for i := range want {
+		if w.lines[i] != want[i] {
+			t.Errorf("line = %q, want %q", w.lines[i], want[i])
+		}
+	}
+}
+
+func TestWithSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	defer conn.Close()
+
+	opt, err := syslog.WithSyslog("udp", conn.LocalAddr().String(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calldepth.New(opt).Warn("disk almost full")
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, 1024)
+
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user facility and warning severity.
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<12>") || !strings.Contains(msg, " app[") || !strings.HasSuffix(msg, `msg="disk almost full"`+"\n") {
+		t.Errorf("message = %q, want the warning at the user facility with the tag", msg)
+	}
+}